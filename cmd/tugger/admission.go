@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// AdmissionReview API versions understood by the webhook handlers
+const (
+	admissionV1      = "admission.k8s.io/v1"
+	admissionV1beta1 = "admission.k8s.io/v1beta1"
+)
+
+// decodeAdmissionReview parses an AdmissionReview sent as either admission.k8s.io/v1 or v1beta1.
+// The request is returned as v1 along with the API version it was sent in, so the response can be
+// answered in the same version. Reviews without an apiVersion are treated as v1beta1.
+func decodeAdmissionReview(data []byte) (*admissionv1.AdmissionRequest, string, error) {
+	tm := metav1.TypeMeta{}
+	if err := json.Unmarshal(data, &tm); err != nil {
+		return nil, "", err
+	}
+
+	switch tm.APIVersion {
+	case admissionV1:
+		ar := admissionv1.AdmissionReview{}
+		if err := json.Unmarshal(data, &ar); err != nil {
+			return nil, admissionV1, err
+		}
+		if ar.Request == nil {
+			return nil, admissionV1, fmt.Errorf("admission review has no request")
+		}
+		return ar.Request, admissionV1, nil
+	case admissionV1beta1, "":
+		ar := v1beta1.AdmissionReview{}
+		if err := json.Unmarshal(data, &ar); err != nil {
+			return nil, admissionV1beta1, err
+		}
+		if ar.Request == nil {
+			return nil, admissionV1beta1, fmt.Errorf("admission review has no request")
+		}
+		return v1beta1ToV1Request(ar.Request), admissionV1beta1, nil
+	default:
+		return nil, tm.APIVersion, fmt.Errorf("unsupported admission review version %s", tm.APIVersion)
+	}
+}
+
+// encodeAdmissionReview serializes the response as an AdmissionReview of the given API version,
+// echoing the request UID back to the API server
+func encodeAdmissionReview(apiVersion string, uid types.UID, resp *admissionv1.AdmissionResponse) ([]byte, error) {
+	resp.UID = uid
+	if apiVersion == admissionV1 {
+		return json.Marshal(admissionv1.AdmissionReview{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: admissionV1,
+				Kind:       "AdmissionReview",
+			},
+			Response: resp,
+		})
+	}
+	return json.Marshal(v1beta1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionV1beta1,
+			Kind:       "AdmissionReview",
+		},
+		Response: v1ToV1beta1Response(resp),
+	})
+}
+
+// writeAdmissionResponse answers an admission request with resp, in the same API version and with
+// the same UID as the request. Both the mutating and validating handlers respond through here.
+func writeAdmissionResponse(w http.ResponseWriter, apiVersion string, req *admissionv1.AdmissionRequest, resp *admissionv1.AdmissionResponse) {
+	data, err := encodeAdmissionReview(apiVersion, req.UID, resp)
+	if err != nil {
+		log.WithError(err).WithField("resp", resp).Error("could not marshal response")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+// v1beta1ToV1Request converts a v1beta1 AdmissionRequest to v1, the versions are field-for-field identical
+func v1beta1ToV1Request(in *v1beta1.AdmissionRequest) *admissionv1.AdmissionRequest {
+	return &admissionv1.AdmissionRequest{
+		UID:                in.UID,
+		Kind:               in.Kind,
+		Resource:           in.Resource,
+		SubResource:        in.SubResource,
+		RequestKind:        in.RequestKind,
+		RequestResource:    in.RequestResource,
+		RequestSubResource: in.RequestSubResource,
+		Name:               in.Name,
+		Namespace:          in.Namespace,
+		Operation:          admissionv1.Operation(in.Operation),
+		UserInfo:           in.UserInfo,
+		Object:             in.Object,
+		OldObject:          in.OldObject,
+		DryRun:             in.DryRun,
+		Options:            in.Options,
+	}
+}
+
+// v1ToV1beta1Response converts a v1 AdmissionResponse to v1beta1, the versions are field-for-field identical
+func v1ToV1beta1Response(in *admissionv1.AdmissionResponse) *v1beta1.AdmissionResponse {
+	out := &v1beta1.AdmissionResponse{
+		UID:              in.UID,
+		Allowed:          in.Allowed,
+		Result:           in.Result,
+		Patch:            in.Patch,
+		AuditAnnotations: in.AuditAnnotations,
+		Warnings:         in.Warnings,
+	}
+	if in.PatchType != nil {
+		pt := v1beta1.PatchType(*in.PatchType)
+		out.PatchType = &pt
+	}
+	return out
+}
+
+// admissionError is an admission request that could not be handled, answered with an HTTP status
+// instead of an admission response
+type admissionError struct {
+	status int
+	err    error
+}
+
+func (e *admissionError) Error() string {
+	return e.err.Error()
+}
+
+// admittedName returns the name of the admitted object with metadata obj, or its generateName
+// followed by * when the API server has yet to name it, e.g. a pod created by a ReplicaSet
+func admittedName(req *admissionv1.AdmissionRequest, obj *metav1.PartialObjectMetadata) string {
+	name := obj.Name
+	if name == "" {
+		name = req.Name
+	}
+	if name == "" && obj.GenerateName != "" {
+		name = obj.GenerateName + "*"
+	}
+	return name
+}
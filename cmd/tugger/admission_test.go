@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_decodeAdmissionReview(t *testing.T) {
+	tests := []struct {
+		name        string
+		in          string
+		wantVersion string
+		wantUID     types.UID
+		wantErr     bool
+	}{
+		{
+			name:        "v1",
+			in:          `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview","request":{"uid":"abc","namespace":"foobar","operation":"CREATE"}}`,
+			wantVersion: admissionV1,
+			wantUID:     "abc",
+		},
+		{
+			name:        "v1beta1",
+			in:          `{"apiVersion":"admission.k8s.io/v1beta1","kind":"AdmissionReview","request":{"uid":"def","namespace":"foobar","operation":"CREATE"}}`,
+			wantVersion: admissionV1beta1,
+			wantUID:     "def",
+		},
+		{
+			name:        "no version",
+			in:          `{"kind":"AdmissionReview","request":{"uid":"ghi","namespace":"foobar"}}`,
+			wantVersion: admissionV1beta1,
+			wantUID:     "ghi",
+		},
+		{
+			name:    "unsupported version",
+			in:      `{"apiVersion":"admission.k8s.io/v2","kind":"AdmissionReview","request":{"uid":"abc"}}`,
+			wantErr: true,
+		},
+		{
+			name:    "v1 missing request",
+			in:      `{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`,
+			wantErr: true,
+		},
+		{
+			name:    "v1beta1 missing request",
+			in:      `{}`,
+			wantErr: true,
+		},
+		{
+			name:    "not json",
+			in:      `not json`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, version, err := decodeAdmissionReview([]byte(tt.in))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeAdmissionReview() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if version != tt.wantVersion {
+				t.Errorf("decodeAdmissionReview() version = %v, want %v", version, tt.wantVersion)
+			}
+			if req.UID != tt.wantUID {
+				t.Errorf("decodeAdmissionReview() uid = %v, want %v", req.UID, tt.wantUID)
+			}
+			if req.Namespace != "foobar" {
+				t.Errorf("decodeAdmissionReview() namespace = %v, want foobar", req.Namespace)
+			}
+		})
+	}
+}
+
+func Test_encodeAdmissionReview(t *testing.T) {
+	pt := admissionv1.PatchTypeJSONPatch
+	tests := []struct {
+		name       string
+		apiVersion string
+		resp       admissionv1.AdmissionResponse
+		want       string
+	}{
+		{
+			name:       "v1",
+			apiVersion: admissionV1,
+			resp:       admissionv1.AdmissionResponse{Allowed: true},
+			want:       `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"abc","allowed":true}}`,
+		},
+		{
+			name:       "v1beta1",
+			apiVersion: admissionV1beta1,
+			resp:       admissionv1.AdmissionResponse{Allowed: true, Patch: []byte(`[]`), PatchType: &pt},
+			want:       `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"abc","allowed":true,"patch":"W10=","patchType":"JSONPatch"}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := encodeAdmissionReview(tt.apiVersion, "abc", &tt.resp)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("encodeAdmissionReview() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/infobloxopen/atlas-app-toolkit/logging"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// Exit codes of the subcommands
+const (
+	exitOK = 0
+	// exitFailure is an image that is denied or a test that fails
+	exitFailure = 1
+	// exitError is input that can not be read
+	exitError = 2
+)
+
+// manifestExtensions are the files read from directories by tugger check
+var manifestExtensions = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+// manifest is an object read from a manifest file
+type manifest struct {
+	source string
+	raw    []byte
+}
+
+// checkResult is what the admission controllers would do with a manifest
+type checkResult struct {
+	Source    string `json:"source"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Skipped explains why the images of the object are not checked, e.g. kind is not handled
+	Skipped    string              `json:"skipped,omitempty"`
+	Allowed    bool                `json:"allowed"`
+	Containers []containerDecision `json:"containers,omitempty"`
+	// Patch is the JSON patch of the mutating admission controller
+	Patch json.RawMessage `json:"patch,omitempty"`
+	// Warnings are the images denied in warn and audit mode
+	Warnings []string `json:"warnings,omitempty"`
+	Message  string   `json:"message,omitempty"`
+}
+
+// runCheck implements tugger check: the manifests in args (files, directories or - for stdin) are
+// run through the mutating and then the validating admission controller, like the API server
+// would, and the decisions are written to stdout. It returns the exit code.
+func runCheck(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("check", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tugger check [flags] [file|directory|-]...")
+		fmt.Fprintln(stderr, "Reports what tugger would do with the images of Kubernetes manifests, reading stdin without arguments.")
+		fmt.Fprintln(stderr, "Exits 1 when an image is denied and 2 when a manifest can not be checked.")
+		flags.PrintDefaults()
+	}
+	policyFile := flags.String("policy-file", "", "YAML file defining allowed image name patterns (see readme), WHITELIST_REGISTRIES is used without it")
+	namespace := flags.String("namespace", "default", "namespace of objects that do not set one")
+	output := flags.String("output", "text", "output format: text or json")
+	showSkipped := flags.Bool("show-skipped", false, "also report objects whose images are not checked, e.g. ConfigMaps")
+	logLevel := flags.String("log-level", "error", "log verbosity, logs are written to stderr")
+	flags.BoolVar(&ifExists, "if-exists", false, "makes the mutation conditional on whether the mutated image name exists in the registry")
+	flags.BoolVar(&pinDigests, "pin-digests", false, "makes the mutation pin every allowed image to the digest its tag resolves to")
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "output must be text or json, not %s\n", *output)
+		return exitError
+	}
+
+	log = logging.New(*logLevel)
+	log.SetOutput(stderr)
+
+	if *policyFile != "" {
+		p, err := NewPolicy(WithConfigFile(*policyFile))
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to load policy file %s: %v\n", *policyFile, err)
+			return exitError
+		}
+		setPolicy(p)
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"-"}
+	}
+	manifests, err := readManifests(paths, stdin)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	code := exitOK
+	results := []checkResult{}
+	for _, m := range manifests {
+		result, err := checkManifest(m, *namespace)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", m.source, err)
+			code = exitError
+			continue
+		}
+		if !result.Allowed && code == exitOK {
+			code = exitFailure
+		}
+		if result.Skipped != "" && !*showSkipped {
+			continue
+		}
+		results = append(results, *result)
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+	} else {
+		for _, result := range results {
+			writeCheckResult(stdout, result)
+		}
+	}
+	return code
+}
+
+// readManifests reads the objects of every YAML or JSON document in paths, walking directories
+// for .yaml, .yml and .json files. "-" reads stdin.
+func readManifests(paths []string, stdin io.Reader) ([]manifest, error) {
+	manifests := []manifest{}
+	for _, path := range paths {
+		if path == "-" {
+			objs, err := decodeManifests("-", stdin)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, objs...)
+			continue
+		}
+		err := filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || (file != path && !manifestExtensions[strings.ToLower(filepath.Ext(file))]) {
+				return nil
+			}
+			f, err := os.Open(file)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			objs, err := decodeManifests(file, f)
+			if err != nil {
+				return err
+			}
+			manifests = append(manifests, objs...)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return manifests, nil
+}
+
+// decodeManifests splits a multi-document YAML or JSON stream into objects, expanding the items
+// of List objects and skipping empty documents
+func decodeManifests(source string, r io.Reader) ([]manifest, error) {
+	manifests := []manifest{}
+	decoder := k8syaml.NewYAMLOrJSONDecoder(bufio.NewReader(r), 4096)
+	for {
+		var obj map[string]interface{}
+		if err := decoder.Decode(&obj); err != nil {
+			if err == io.EOF {
+				return manifests, nil
+			}
+			return nil, fmt.Errorf("%s: %w", source, err)
+		}
+		if len(obj) == 0 {
+			continue
+		}
+		objs := []interface{}{obj}
+		if items, ok := obj["items"].([]interface{}); ok && strings.HasSuffix(fmt.Sprint(obj["kind"]), "List") {
+			objs = items
+		}
+		for _, o := range objs {
+			raw, err := json.Marshal(o)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", source, err)
+			}
+			manifests = append(manifests, manifest{source: source, raw: raw})
+		}
+	}
+}
+
+// checkManifest admits an object as a create request, first through the mutating admission
+// controller and then the mutated object through the validating admission controller
+func checkManifest(m manifest, defaultNamespace string) (*checkResult, error) {
+	obj := metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(m.raw, &obj); err != nil {
+		return nil, err
+	}
+	if obj.Kind == "" || obj.APIVersion == "" {
+		return nil, fmt.Errorf("object %s has no apiVersion or kind", obj.Name)
+	}
+	gv, err := schema.ParseGroupVersion(obj.APIVersion)
+	if err != nil {
+		return nil, err
+	}
+	namespace := obj.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	name := obj.Name
+	if name == "" {
+		name = obj.GenerateName
+	}
+	req := &admissionv1.AdmissionRequest{
+		UID:       "tugger-check",
+		Kind:      metav1.GroupVersionKind{Group: gv.Group, Version: gv.Version, Kind: obj.Kind},
+		Namespace: namespace,
+		Name:      name,
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: m.raw},
+	}
+	result := &checkResult{Source: m.source, Kind: obj.Kind, Namespace: namespace, Name: name}
+
+	mutated, mutateRecord, admitErr := mutateRequest(req)
+	if admitErr != nil {
+		return nil, admitErr.err
+	}
+	if len(mutated.Patch) > 0 {
+		result.Patch = mutated.Patch
+		patch, err := jsonpatch.DecodePatch(mutated.Patch)
+		if err != nil {
+			return nil, fmt.Errorf("invalid patch %s: %w", mutated.Patch, err)
+		}
+		if req.Object.Raw, err = patch.Apply(m.raw); err != nil {
+			return nil, fmt.Errorf("could not apply patch %s: %w", mutated.Patch, err)
+		}
+	}
+
+	validated, validateRecord, admitErr := validateRequest(req)
+	if admitErr != nil {
+		return nil, admitErr.err
+	}
+	result.Allowed = validated.Allowed
+	result.Skipped = validateRecord.Reason
+	result.Warnings = validated.Warnings
+	if denied := validated.AuditAnnotations["would-deny"]; denied != "" {
+		result.Warnings = append(result.Warnings, denied)
+	}
+	if validated.Result != nil {
+		result.Message = validated.Result.Message
+	}
+
+	// The validating admission controller sees the mutated images, the original images and the
+	// reasons for rewriting them come from the mutating one
+	for i, c := range validateRecord.Containers {
+		if i < len(mutateRecord.Containers) {
+			mc := mutateRecord.Containers[i]
+			c.OriginalImage = mc.OriginalImage
+			if c.Allowed && (mc.OriginalImage != mc.FinalImage || mc.Reason != "") {
+				c.Rule, c.Reason = mc.Rule, mc.Reason
+			}
+		}
+		result.Containers = append(result.Containers, c)
+	}
+	return result, nil
+}
+
+// writeCheckResult writes a result as text
+func writeCheckResult(w io.Writer, result checkResult) {
+	status := "allowed"
+	switch {
+	case result.Skipped != "":
+		status = "skipped, " + result.Skipped
+	case !result.Allowed:
+		status = "denied"
+	case len(result.Warnings) > 0:
+		status = "allowed with warnings"
+	}
+	fmt.Fprintf(w, "%s: %s %s/%s: %s\n", result.Source, result.Kind, result.Namespace, result.Name, status)
+	for _, c := range result.Containers {
+		line := fmt.Sprintf("  %s %s: %s", c.Type, c.Name, c.OriginalImage)
+		if c.FinalImage != c.OriginalImage {
+			line += " -> " + c.FinalImage
+		}
+		if c.Allowed {
+			line += " allowed"
+		} else {
+			line += " denied"
+		}
+		if c.Rule != 0 {
+			line += fmt.Sprintf(" by rule %d", c.Rule)
+		}
+		// deny rules without a message already give the rule as the reason
+		if c.Reason != "" && c.Reason != fmt.Sprintf("denied by rule %d", c.Rule) {
+			line += ": " + c.Reason
+		}
+		fmt.Fprintln(w, line)
+	}
+	for _, warning := range result.Warnings {
+		fmt.Fprintf(w, "  warning: %s\n", warning)
+	}
+	if len(result.Patch) > 0 {
+		fmt.Fprintf(w, "  patch: %s\n", result.Patch)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const checkPolicy = `
+rules:
+- pattern: ^mysql$
+  action: deny
+  message: use the private mysql image
+- pattern: ^nginx$
+  replacement: private.io/nginx
+- pattern: ^private.io/.*
+`
+
+const checkNoMessagePolicy = `
+rules:
+- pattern: ^mysql$
+  action: deny
+- pattern: .*
+`
+
+const checkDeployment = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: nginx
+        image: nginx
+      initContainers:
+      - name: mysql
+        image: mysql
+`
+
+const checkPods = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+---
+apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata:
+    name: app
+    namespace: prod
+  spec:
+    containers:
+    - name: app
+      image: private.io/app:1
+`
+
+// writeCheckFiles writes files into a temporary directory and returns its path
+func writeCheckFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRunCheck(t *testing.T) {
+	dir := writeCheckFiles(t, map[string]string{
+		"policy.yaml":             checkPolicy,
+		"no-message.yaml":         checkNoMessagePolicy,
+		"manifests/web.yaml":      checkDeployment,
+		"manifests/pods.yml":      checkPods,
+		"manifests/README.md":     "not a manifest",
+		"allowed/pods.json":       `{"apiVersion":"v1","kind":"Pod","metadata":{"name":"app"},"spec":{"containers":[{"name":"app","image":"private.io/app:1"}]}}`,
+		"invalid/manifest.yaml":   "apiVersion: v1\nkind: [",
+		"invalid/no-kind.yaml":    "metadata:\n  name: foo\n",
+		"manifests/sub/empty.yml": "---\n",
+	})
+	policyFile := filepath.Join(dir, "policy.yaml")
+	defaultLog := log
+	defer func() {
+		log = defaultLog
+		setPolicy(nil)
+	}()
+
+	tests := []struct {
+		name     string
+		args     []string
+		stdin    string
+		want     []string
+		wantNot  []string
+		wantCode int
+	}{
+		{
+			name:     "denied",
+			args:     []string{"--policy-file", policyFile, filepath.Join(dir, "manifests")},
+			wantCode: exitFailure,
+			want: []string{
+				"web.yaml: Deployment default/web: denied",
+				"  container nginx: nginx -> private.io/nginx allowed by rule 2",
+				"  init container mysql: mysql denied by rule 1: use the private mysql image",
+				`  patch: [{"op":"add","path":"/spec/template/metadata","value":{}}`,
+				"pods.yml: Pod prod/app: allowed",
+			},
+			wantNot: []string{"ConfigMap", "README"},
+		},
+		{
+			name:     "show skipped",
+			args:     []string{"--policy-file", policyFile, "--show-skipped", filepath.Join(dir, "manifests", "pods.yml")},
+			wantCode: exitOK,
+			want:     []string{"ConfigMap default/config: skipped, kind is not handled", "Pod prod/app: allowed"},
+		},
+		{
+			name:     "stdin",
+			args:     []string{"--policy-file", policyFile, "--namespace", "team-a"},
+			stdin:    checkDeployment,
+			wantCode: exitFailure,
+			want:     []string{"-: Deployment team-a/web: denied"},
+		},
+		{
+			name:     "deny rule without message",
+			args:     []string{"--policy-file", filepath.Join(dir, "no-message.yaml"), filepath.Join(dir, "manifests", "web.yaml")},
+			wantCode: exitFailure,
+			want:     []string{"  init container mysql: mysql denied by rule 1\n"},
+			wantNot:  []string{"denied by rule 1: denied by rule 1"},
+		},
+		{
+			name:     "json file",
+			args:     []string{"--policy-file", policyFile, filepath.Join(dir, "allowed")},
+			wantCode: exitOK,
+			want:     []string{"pods.json: Pod default/app: allowed"},
+		},
+		{
+			name:     "invalid manifest",
+			args:     []string{"--policy-file", policyFile, filepath.Join(dir, "invalid", "manifest.yaml")},
+			wantCode: exitError,
+		},
+		{
+			name:     "no kind",
+			args:     []string{"--policy-file", policyFile, filepath.Join(dir, "invalid", "no-kind.yaml")},
+			wantCode: exitError,
+		},
+		{
+			name:     "missing policy file",
+			args:     []string{"--policy-file", filepath.Join(dir, "missing.yaml")},
+			wantCode: exitError,
+		},
+		{
+			name:     "invalid output",
+			args:     []string{"--output", "xml"},
+			wantCode: exitError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer setPolicy(nil)
+			var stdout, stderr bytes.Buffer
+			code := runCheck(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Errorf("runCheck() = %v, want %v, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("output does not contain %q:\n%s", want, stdout.String())
+				}
+			}
+			for _, notWant := range tt.wantNot {
+				if strings.Contains(stdout.String(), notWant) {
+					t.Errorf("output contains %q:\n%s", notWant, stdout.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRunCheck_json(t *testing.T) {
+	dir := writeCheckFiles(t, map[string]string{"policy.yaml": checkPolicy})
+	defaultLog := log
+	defer func() {
+		log = defaultLog
+		setPolicy(nil)
+	}()
+
+	var stdout, stderr bytes.Buffer
+	code := runCheck([]string{"--policy-file", filepath.Join(dir, "policy.yaml"), "--output", "json"}, strings.NewReader(checkDeployment), &stdout, &stderr)
+	if code != exitFailure {
+		t.Errorf("runCheck() = %v, want %v, stderr: %s", code, exitFailure, stderr.String())
+	}
+	results := []checkResult{}
+	if err := json.Unmarshal(stdout.Bytes(), &results); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, stdout.String())
+	}
+	if len(results) != 1 || len(results[0].Containers) != 2 {
+		t.Fatalf("results = %+v, want one result with two containers", results)
+	}
+	want := containerDecision{Name: "nginx", Type: "container", OriginalImage: "nginx", FinalImage: "private.io/nginx", Allowed: true, Rule: 2}
+	if got := results[0].Containers[0]; got != want {
+		t.Errorf("container = %+v, want %+v", got, want)
+	}
+	if results[0].Allowed || !strings.Contains(results[0].Message, "use the private mysql image") {
+		t.Errorf("result = %+v, want denied with the rule message", results[0])
+	}
+}
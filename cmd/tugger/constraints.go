@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// semverTag matches semantic version tags, optionally prefixed with v, e.g. 1.19.2 or v2.0.0-rc.1
+var semverTag = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?(\+[0-9A-Za-z-]+(\.[0-9A-Za-z-]+)*)?$`)
+
+// Constraints restrict the tags and digests of images allowed by a rule
+type Constraints struct {
+	// RequireDigest only allows images pinned by digest
+	RequireDigest bool `yaml:"requireDigest,omitempty"`
+	// ForbidLatest denies the latest tag, including images without tag or digest
+	ForbidLatest bool `yaml:"forbidLatest,omitempty"`
+	// SemverTag only allows tags that are semantic versions
+	SemverTag bool `yaml:"semverTag,omitempty"`
+}
+
+// Check returns why an image violates the constraints, or an empty string if it satisfies them
+func (c *Constraints) Check(image string) string {
+	ref, err := parseImageRef(image)
+	if err != nil {
+		log.WithError(err).WithField("image", image).Debug("could not parse image")
+		return "image name can not be parsed"
+	}
+	if c.RequireDigest && ref.Digest == "" {
+		return "image must be pinned by digest"
+	}
+	if c.ForbidLatest && ref.Tag == "latest" {
+		if !hasTag(image) && ref.Digest == "" {
+			return "image has no tag, which defaults to the mutable latest tag"
+		}
+		return "the latest tag is mutable"
+	}
+	if c.SemverTag {
+		if ref.Tag == "" {
+			return "image has no semantic version tag"
+		}
+		if !semverTag.MatchString(ref.Tag) {
+			return fmt.Sprintf("tag %s is not a semantic version", ref.Tag)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestConstraints_Check(t *testing.T) {
+	digest := "sha256:2d4f6d8aec48cf4e5b9a5e9d4e8f5d5c8a4b8f3d3c1e0f4e1a5a8f6b7c9d0e1f"
+	tests := []struct {
+		name        string
+		constraints Constraints
+		image       string
+		want        string
+	}{
+		{
+			name:        "digest",
+			constraints: Constraints{RequireDigest: true},
+			image:       "nginx:1.19@" + digest,
+			want:        "",
+		},
+		{
+			name:        "missing digest",
+			constraints: Constraints{RequireDigest: true},
+			image:       "nginx:1.19",
+			want:        "image must be pinned by digest",
+		},
+		{
+			name:        "latest",
+			constraints: Constraints{ForbidLatest: true},
+			image:       "nginx:latest",
+			want:        "the latest tag is mutable",
+		},
+		{
+			name:        "no tag",
+			constraints: Constraints{ForbidLatest: true},
+			image:       "nginx",
+			want:        "image has no tag, which defaults to the mutable latest tag",
+		},
+		{
+			name:        "latest with digest",
+			constraints: Constraints{ForbidLatest: true},
+			image:       "nginx:latest@" + digest,
+			want:        "the latest tag is mutable",
+		},
+		{
+			name:        "digest without tag",
+			constraints: Constraints{ForbidLatest: true},
+			image:       "nginx@" + digest,
+			want:        "",
+		},
+		{
+			name:        "semver",
+			constraints: Constraints{SemverTag: true},
+			image:       "nginx:1.19.2",
+			want:        "",
+		},
+		{
+			name:        "semver prefix and prerelease",
+			constraints: Constraints{SemverTag: true},
+			image:       "nginx:v2.0.0-rc.1",
+			want:        "",
+		},
+		{
+			name:        "not semver",
+			constraints: Constraints{SemverTag: true},
+			image:       "nginx:1.19",
+			want:        "tag 1.19 is not a semantic version",
+		},
+		{
+			name:        "semver without tag",
+			constraints: Constraints{SemverTag: true},
+			image:       "nginx@" + digest,
+			want:        "image has no semantic version tag",
+		},
+		{
+			name:        "doesn't parse",
+			constraints: Constraints{RequireDigest: true},
+			image:       "Nginx:%",
+			want:        "image name can not be parsed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.constraints.Check(tt.image); got != tt.want {
+				t.Errorf("Constraints.Check() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
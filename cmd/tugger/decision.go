@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// decisions writes one JSON decision record per admission, nil disables the decision log
+var decisions *decisionLogger
+
+// decisionRecord describes what tugger decided for one admission request and why
+type decisionRecord struct {
+	Time      time.Time `json:"time"`
+	Handler   string    `json:"handler"`
+	UID       string    `json:"uid"`
+	User      string    `json:"user"`
+	Groups    []string  `json:"groups,omitempty"`
+	Operation string    `json:"operation"`
+	Namespace string    `json:"namespace"`
+	Kind      string    `json:"kind"`
+	Name      string    `json:"name,omitempty"`
+	// RuleSet is the policy block or WHITELIST_REGISTRIES the images were evaluated against
+	RuleSet    string `json:"ruleSet,omitempty"`
+	PolicyHash string `json:"policyHash,omitempty"`
+	// Mode is the enforcement mode of the validating admission controller
+	Mode       string              `json:"mode,omitempty"`
+	Containers []containerDecision `json:"containers"`
+	// Reason explains why no containers were evaluated, e.g. a whitelisted namespace
+	Reason  string `json:"reason,omitempty"`
+	Outcome string `json:"outcome"`
+}
+
+// containerDecision describes the decision for one container
+type containerDecision struct {
+	Name          string `json:"name"`
+	Type          string `json:"type"`
+	OriginalImage string `json:"originalImage"`
+	FinalImage    string `json:"finalImage"`
+	Allowed       bool   `json:"allowed"`
+	// Rule is the position of the policy rule that decided the image, starting at 1
+	Rule   int    `json:"rule,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// newDecisionRecord starts the decision record for an admission request
+func newDecisionRecord(handler string, req *admissionv1.AdmissionRequest) *decisionRecord {
+	obj := metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(req.Object.Raw, &obj); err != nil {
+		log.WithError(err).Debug("could not decode object metadata for the decision record")
+	}
+	return &decisionRecord{
+		Time:       time.Now().UTC(),
+		Handler:    handler,
+		UID:        string(req.UID),
+		User:       req.UserInfo.Username,
+		Groups:     req.UserInfo.Groups,
+		Operation:  string(req.Operation),
+		Namespace:  req.Namespace,
+		Kind:       req.Kind.Kind,
+		Name:       admittedName(req, &obj),
+		Containers: []containerDecision{},
+	}
+}
+
+// addContainer records the decision for a container, whose image was original before mutation
+func (r *decisionRecord) addContainer(ref containerRef, original string, d decision) {
+	r.Containers = append(r.Containers, containerDecision{
+		Name:          ref.Name,
+		Type:          containerTypes[ref.field],
+		OriginalImage: original,
+		FinalImage:    ref.Image,
+		Allowed:       d.allowed,
+		Rule:          d.rule,
+		Reason:        d.reason,
+	})
+}
+
+// setRuleSet records the rule set the containers are evaluated against, p is the rule set of the
+// namespace within the policy snapshot of the request
+func (r *decisionRecord) setRuleSet(snapshot, p *Policy) {
+	if p == nil {
+		r.RuleSet = "WHITELIST_REGISTRIES"
+		return
+	}
+	r.RuleSet = p.RuleSet()
+	r.PolicyHash = snapshot.Hash()
+}
+
+// decisionLogger writes decision records as JSON lines
+type decisionLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// newDecisionLogger writes decision records to stdout, or to a file rotated after maxSize
+// megabytes keeping maxBackups old files for maxAge days
+func newDecisionLogger(destination string, maxSize, maxBackups, maxAge int) *decisionLogger {
+	if destination == "stdout" || destination == "-" {
+		return &decisionLogger{w: os.Stdout}
+	}
+	return &decisionLogger{w: &lumberjack.Logger{
+		Filename:   destination,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+		MaxAge:     maxAge,
+	}}
+}
+
+// Log completes the record with the outcome of the response and writes it
+func (l *decisionLogger) Log(record *decisionRecord, resp *admissionv1.AdmissionResponse) {
+	if l == nil {
+		return
+	}
+	record.Outcome = admissionOutcome(resp)
+	line, err := json.Marshal(record)
+	if err != nil {
+		log.WithError(err).Error("could not marshal decision record")
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(line, '\n')); err != nil {
+		log.WithError(err).Error("could not write decision record")
+	}
+}
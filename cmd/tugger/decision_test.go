@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestDecisionLog(t *testing.T) {
+	whitelistNamespaces = "kube-system"
+	whitelistedNamespaces = strings.Split(whitelistNamespaces, ",")
+	policy, _ = NewPolicy()
+	if err := policy.Load([]byte(`
+rules:
+- pattern: ^mysql$
+  action: deny
+  message: use the mysql image of the private registry
+- pattern: ^nginx$
+  replacement: ` + trustedRegistry + `/nginx
+- pattern: .*
+`)); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		policy = nil
+		decisions = nil
+	}()
+
+	tests := []struct {
+		name       string
+		handler    http.HandlerFunc
+		reqBody    string
+		outcome    string
+		containers []containerDecision
+		reason     string
+	}{
+		{
+			name:    "validate denied",
+			handler: validateAdmissionReviewHandler,
+			reqBody: mixedTrustAdmissionRequest,
+			outcome: outcomeDenied,
+			containers: []containerDecision{
+				{Name: "nginx-frontend", Type: "container", OriginalImage: trustedRegistry + "/nginx", FinalImage: trustedRegistry + "/nginx", Allowed: true, Rule: 3},
+				{Name: "mysql-backend", Type: "container", OriginalImage: trustedRegistry + "/mysql", FinalImage: trustedRegistry + "/mysql", Allowed: true, Rule: 3},
+				{Name: "nginx-frontend", Type: "init container", OriginalImage: trustedRegistry + "/nginx", FinalImage: trustedRegistry + "/nginx", Allowed: true, Rule: 3},
+				{Name: "mysql-backend", Type: "init container", OriginalImage: "mysql", FinalImage: "mysql", Rule: 1, Reason: "use the mysql image of the private registry"},
+			},
+		},
+		{
+			name:    "mutate rewritten",
+			handler: mutateAdmissionReviewHandler,
+			reqBody: untrustedAdmissionRequestV1,
+			outcome: outcomeMutated,
+			containers: []containerDecision{
+				{Name: "nginx-frontend", Type: "container", OriginalImage: "nginx", FinalImage: trustedRegistry + "/nginx", Allowed: true, Rule: 2},
+			},
+		},
+		{
+			name:       "whitelisted namespace",
+			handler:    validateAdmissionReviewHandler,
+			reqBody:    whitelistedAdmissionRequest,
+			outcome:    outcomeAllowed,
+			containers: []containerDecision{},
+			reason:     "namespace is whitelisted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			decisions = &decisionLogger{w: &buf}
+
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.reqBody))
+			tt.handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+			if len(lines) != 1 {
+				t.Fatalf("decision log has %d lines, want 1: %s", len(lines), buf.String())
+			}
+			var record decisionRecord
+			if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+				t.Fatal(err)
+			}
+			if record.Outcome != tt.outcome {
+				t.Errorf("outcome = %v, want %v", record.Outcome, tt.outcome)
+			}
+			if record.Reason != tt.reason {
+				t.Errorf("reason = %v, want %v", record.Reason, tt.reason)
+			}
+			if record.UID == "" || record.Kind != "Pod" {
+				t.Errorf("record does not identify the request: %s", lines[0])
+			}
+			if len(record.Containers) != len(tt.containers) {
+				t.Fatalf("containers = %+v, want %+v", record.Containers, tt.containers)
+			}
+			for i, want := range tt.containers {
+				if got := record.Containers[i]; got != want {
+					t.Errorf("container %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecisionLogger(t *testing.T) {
+	var nilLogger *decisionLogger
+	nilLogger.Log(&decisionRecord{}, &admissionv1.AdmissionResponse{Allowed: true})
+
+	file := filepath.Join(t.TempDir(), "decisions.log")
+	logger := newDecisionLogger(file, 1, 1, 1)
+	logger.Log(&decisionRecord{UID: "1"}, &admissionv1.AdmissionResponse{Allowed: true})
+	logger.Log(&decisionRecord{UID: "2"}, &admissionv1.AdmissionResponse{Allowed: false})
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`"uid":"1"`, `"outcome":"allowed"`, `"uid":"2"`, `"outcome":"denied"`}
+	for _, s := range want {
+		if !strings.Contains(string(data), s) {
+			t.Errorf("decision log %s does not contain %s", data, s)
+		}
+	}
+}
+
+func TestDecisionRecord_setRuleSet(t *testing.T) {
+	load := func(in string) *Policy {
+		p, _ := NewPolicy()
+		if err := p.Load([]byte(in)); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	snapshot := load("rules:\n- pattern: .*\npolicies:\n- name: dev\n  namespaces: [dev]\n  rules:\n  - pattern: ^nginx$\n")
+	// a reload between evaluating and recording must not change the recorded hash
+	setPolicy(load("rules:\n- pattern: ^mysql$\n"))
+	defer setPolicy(nil)
+
+	r := &decisionRecord{}
+	r.setRuleSet(snapshot, snapshot.ForNamespace("dev"))
+	if r.RuleSet != "policy dev" || r.PolicyHash != snapshot.Hash() {
+		t.Errorf("setRuleSet() = %v %v, want policy dev %v", r.RuleSet, r.PolicyHash, snapshot.Hash())
+	}
+
+	r = &decisionRecord{}
+	r.setRuleSet(nil, nil)
+	if r.RuleSet != "WHITELIST_REGISTRIES" || r.PolicyHash != "" {
+		t.Errorf("setRuleSet() = %v %v, want WHITELIST_REGISTRIES without hash", r.RuleSet, r.PolicyHash)
+	}
+}
+
+func Test_newDecisionRecord_name(t *testing.T) {
+	tests := []struct {
+		name    string
+		reqName string
+		object  string
+		want    string
+	}{
+		{name: "named", reqName: "myapp", object: `{"metadata":{"name":"myapp"}}`, want: "myapp"},
+		{name: "generated", object: `{"metadata":{"generateName":"myapp-7d9f-"}}`, want: "myapp-7d9f-*"},
+		{name: "request name", reqName: "myapp", object: `{}`, want: "myapp"},
+		{name: "undecodable", object: `[]`, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newDecisionRecord("mutate", &admissionv1.AdmissionRequest{
+				Name:   tt.reqName,
+				Object: runtime.RawExtension{Raw: []byte(tt.object)},
+			})
+			if r.Name != tt.want {
+				t.Errorf("newDecisionRecord() name = %v, want %v", r.Name, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	v1 "k8s.io/api/core/v1"
+)
+
+// pinDigests makes the mutating admission controller pin every allowed image to its manifest digest
+var pinDigests bool
+
+// resolveDigest returns the image pinned to the digest its tag currently points to, e.g.
+// nginx:1.19@sha256:.... Images that already have a digest are returned unchanged.
+func resolveDigest(image string, keychain authn.Keychain) (string, error) {
+	if strings.Contains(image, "@") {
+		return image, nil
+	}
+	tag, err := name.NewTag(image)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := registryContext()
+	defer cancel()
+	start := time.Now()
+	desc, err := remote.Head(tag, registryOptions(ctx, keychain)...)
+	if err != nil {
+		observeRegistryLookup("digest", "error", start)
+		return "", err
+	}
+	observeRegistryLookup("digest", imageFound.String(), start)
+	if !hasTag(image) {
+		image += ":" + tag.TagStr()
+	}
+	return image + "@" + desc.Digest.String(), nil
+}
+
+// pinContainerDigest pins the container's image to its digest. The image is left unpinned when
+// the digest can not be resolved, so a registry outage does not block admission.
+func pinContainerDigest(container *v1.Container, keychain authn.Keychain) {
+	pinned, err := resolveDigest(container.Image, keychain)
+	if err != nil {
+		log.WithError(err).WithField("image", container.Image).Warn("could not resolve image digest, leaving it unpinned")
+		return
+	}
+	if pinned != container.Image {
+		log.Println("Pinning image", container.Image, "to", pinned)
+		container.Image = pinned
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	v1 "k8s.io/api/core/v1"
+)
+
+// runTestRegistry serves an in-memory registry with the image pushed as <host>/repo/nginx:1.19 and
+// <host>/repo/nginx:latest, and returns the registry host and the image digest
+func runTestRegistry(t *testing.T) (string, string) {
+	server := httptest.NewServer(registry.New())
+	t.Cleanup(server.Close)
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"1.19", "latest"} {
+		ref, err := name.NewTag(host + "/repo/nginx:" + tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return host, digest.String()
+}
+
+func Test_resolveDigest(t *testing.T) {
+	host, digest := runTestRegistry(t)
+	tests := []struct {
+		name    string
+		image   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "tag",
+			image: host + "/repo/nginx:1.19",
+			want:  host + "/repo/nginx:1.19@" + digest,
+		},
+		{
+			name:  "implicit latest",
+			image: host + "/repo/nginx",
+			want:  host + "/repo/nginx:latest@" + digest,
+		},
+		{
+			name:  "already pinned",
+			image: host + "/repo/nginx@" + digest,
+			want:  host + "/repo/nginx@" + digest,
+		},
+		{
+			name:    "unknown tag",
+			image:   host + "/repo/nginx:notexist",
+			wantErr: true,
+		},
+		{
+			name:    "doesn't parse",
+			image:   "doesn't parse",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveDigest(tt.image, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("resolveDigest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("resolveDigest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_handleContainer_pinDigests(t *testing.T) {
+	host, digest := runTestRegistry(t)
+	pinDigests = true
+	whitelistedRegistries = registryWhitelist{host}
+	defer func() {
+		pinDigests = false
+		whitelistedRegistries = registryWhitelist(strings.Split(whitelistRegistries, ","))
+	}()
+
+	tests := []struct {
+		name    string
+		policy  string
+		image   string
+		want    string
+		changed bool
+	}{
+		{
+			name:    "whitelisted",
+			image:   host + "/repo/nginx:1.19",
+			want:    host + "/repo/nginx:1.19@" + digest,
+			changed: true,
+		},
+		{
+			name:    "rewritten",
+			policy:  "rules:\n- pattern: ^nginx(:.*)?$\n  replacement: " + host + "/repo/nginx$1\n",
+			image:   "nginx:1.19",
+			want:    host + "/repo/nginx:1.19@" + digest,
+			changed: true,
+		},
+		{
+			name:    "denied",
+			policy:  "rules:\n- pattern: .*\n  action: deny\n",
+			image:   host + "/repo/nginx:1.19",
+			want:    host + "/repo/nginx:1.19",
+			changed: false,
+		},
+		{
+			name:    "unresolvable",
+			image:   host + "/repo/nginx:notexist",
+			want:    host + "/repo/nginx:notexist",
+			changed: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p *Policy
+			if tt.policy != "" {
+				p, _ = NewPolicy()
+				if err := p.Load([]byte(tt.policy)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			container := &v1.Container{Name: "nginx", Image: tt.image}
+			if changed := handleContainer(p, container, "", nil); changed != tt.changed {
+				t.Errorf("handleContainer() = %v, want %v", changed, tt.changed)
+			}
+			if container.Image != tt.want {
+				t.Errorf("handleContainer() image = %v, want %v", container.Image, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+// Event reasons
+const (
+	eventImageDenied        = "ImageDenied"
+	eventImageWouldBeDenied = "ImageWouldBeDenied"
+	eventImageNotFound      = "ImageNotFound"
+	eventImageRewritten     = "ImageRewritten"
+)
+
+// events records Kubernetes Events about admitted objects, nil disables them
+var events record.EventRecorder
+
+// startEventRecorder posts Events through the API server, allowing qps events per second per
+// object with bursts of burst events. Namespaces are cached to reference them in events.
+func startEventRecorder(qps float32, burst int) {
+	client, err := getKubeClient()
+	if err != nil {
+		log.WithError(err).Error("could not create kubernetes client, events will not be recorded")
+		return
+	}
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{
+		QPS:       qps,
+		BurstSize: burst,
+	})
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	events = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "tugger"})
+	startNamespaceInformer()
+}
+
+// eventTarget returns the object events about an admission request are recorded against: the
+// controller that owns the object, the object itself if it already exists, or else its namespace,
+// since a rejected object is never created. Events about another object name the admitted object
+// in the returned prefix.
+func eventTarget(req *admissionv1.AdmissionRequest) (*v1.ObjectReference, string) {
+	obj := metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(req.Object.Raw, &obj); err != nil {
+		log.WithError(err).Debug("could not decode object metadata for events")
+	}
+	prefix := fmt.Sprintf("%s %s: ", req.Kind.Kind, admittedName(req, &obj))
+
+	if owner := metav1.GetControllerOf(&obj); owner != nil {
+		return &v1.ObjectReference{
+			APIVersion: owner.APIVersion,
+			Kind:       owner.Kind,
+			Namespace:  req.Namespace,
+			Name:       owner.Name,
+			UID:        owner.UID,
+		}, prefix
+	}
+	if obj.UID != "" {
+		return &v1.ObjectReference{
+			APIVersion: metav1.GroupVersion{Group: req.Kind.Group, Version: req.Kind.Version}.String(),
+			Kind:       req.Kind.Kind,
+			Namespace:  req.Namespace,
+			Name:       obj.Name,
+			UID:        obj.UID,
+		}, ""
+	}
+	return &v1.ObjectReference{
+		APIVersion: "v1",
+		Kind:       "Namespace",
+		Namespace:  req.Namespace,
+		Name:       req.Namespace,
+		UID:        namespaceUID(req.Namespace),
+	}, prefix
+}
+
+// recordEvent records an event about the object of an admission request, unless events are
+// disabled or the request is a dry run
+func recordEvent(req *admissionv1.AdmissionRequest, eventType, reason, format string, args ...interface{}) {
+	if events == nil || (req.DryRun != nil && *req.DryRun) {
+		return
+	}
+	target, prefix := eventTarget(req)
+	events.Event(target, eventType, reason, prefix+fmt.Sprintf(format, args...))
+}
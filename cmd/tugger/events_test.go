@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+)
+
+func Test_eventTarget(t *testing.T) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if err := indexer.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foobar", UID: "ns-uid"}}); err != nil {
+		t.Fatal(err)
+	}
+	setNamespaceLister(corev1listers.NewNamespaceLister(indexer))
+	defer setNamespaceLister(nil)
+
+	tests := []struct {
+		name       string
+		kind       metav1.GroupVersionKind
+		object     string
+		want       *v1.ObjectReference
+		wantPrefix string
+	}{
+		{
+			name:   "pod owned by a replicaset",
+			kind:   metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			object: `{"metadata":{"generateName":"myapp-7d9f-","ownerReferences":[{"apiVersion":"apps/v1","kind":"ReplicaSet","name":"myapp-7d9f","uid":"rs-uid","controller":true}]}}`,
+			want: &v1.ObjectReference{
+				APIVersion: "apps/v1", Kind: "ReplicaSet", Namespace: "foobar", Name: "myapp-7d9f", UID: "rs-uid",
+			},
+			wantPrefix: "Pod myapp-7d9f-*: ",
+		},
+		{
+			name:   "existing deployment",
+			kind:   metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+			object: `{"metadata":{"name":"myapp","uid":"deploy-uid"}}`,
+			want: &v1.ObjectReference{
+				APIVersion: "apps/v1", Kind: "Deployment", Namespace: "foobar", Name: "myapp", UID: "deploy-uid",
+			},
+		},
+		{
+			name:   "new pod",
+			kind:   metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			object: `{"metadata":{"name":"myapp"}}`,
+			want: &v1.ObjectReference{
+				APIVersion: "v1", Kind: "Namespace", Namespace: "foobar", Name: "foobar", UID: "ns-uid",
+			},
+			wantPrefix: "Pod myapp: ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &admissionv1.AdmissionRequest{
+				Kind:      tt.kind,
+				Namespace: "foobar",
+				Object:    runtime.RawExtension{Raw: []byte(tt.object)},
+			}
+			got, prefix := eventTarget(req)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("eventTarget() = %+v, want %+v", got, tt.want)
+			}
+			if prefix != tt.wantPrefix {
+				t.Errorf("eventTarget() prefix = %q, want %q", prefix, tt.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestEvents(t *testing.T) {
+	whitelistNamespaces = "kube-system"
+	whitelistedNamespaces = strings.Split(whitelistNamespaces, ",")
+	policy, _ = NewPolicy()
+	if err := policy.Load([]byte(`
+rules:
+- pattern: ^mysql$
+  action: deny
+- pattern: ^nginx$
+  replacement: ` + trustedRegistry + `/nginx
+- pattern: .*
+`)); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		policy = nil
+		events = nil
+	}()
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		reqBody string
+		want    []string
+	}{
+		{
+			name:    "denied",
+			handler: validateAdmissionReviewHandler,
+			reqBody: mixedTrustAdmissionRequest,
+			want:    []string{"Warning ImageDenied Pod myapp: init container mysql-backend: Image is denied: mysql"},
+		},
+		{
+			name:    "rewritten",
+			handler: mutateAdmissionReviewHandler,
+			reqBody: untrustedAdmissionRequestV1,
+			want:    []string{"Normal ImageRewritten Pod myapp: container nginx-frontend: rewrote image nginx to " + trustedRegistry + "/nginx"},
+		},
+		{
+			name:    "dry run",
+			handler: validateAdmissionReviewHandler,
+			reqBody: strings.Replace(mixedTrustAdmissionRequest, `"uid":`, `"dryRun": true, "uid":`, 1),
+		},
+		{
+			name:    "allowed",
+			handler: validateAdmissionReviewHandler,
+			reqBody: trustedAdmissionRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := record.NewFakeRecorder(10)
+			events = recorder
+
+			req := httptest.NewRequest("POST", "/", strings.NewReader(tt.reqBody))
+			tt.handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			close(recorder.Events)
+			got := []string{}
+			for event := range recorder.Events {
+				got = append(got, event)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("events = %q, want %q", got, tt.want)
+			}
+			for i := range tt.want {
+				if !strings.HasPrefix(got[i], tt.want[i]) {
+					t.Errorf("event %d = %q, want prefix %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEvents_imageNotFound(t *testing.T) {
+	defer runMockRegistry()()
+	policy, _ = NewPolicy()
+	if err := policy.Load([]byte(`
+rules:
+- pattern: ^nginx$
+  replacement: jainishshah17/nginx:notexist
+  condition: Exists
+`)); err != nil {
+		t.Fatal(err)
+	}
+	recorder := record.NewFakeRecorder(10)
+	events = recorder
+	defer func() {
+		policy = nil
+		events = nil
+	}()
+
+	req := httptest.NewRequest("POST", "/", strings.NewReader(untrustedAdmissionRequestV1))
+	http.HandlerFunc(mutateAdmissionReviewHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+	select {
+	case event := <-recorder.Events:
+		want := "Warning ImageNotFound Pod myapp: container nginx-frontend: jainishshah17/nginx:notexist does not exist in private registry"
+		if !strings.HasPrefix(event, want) {
+			t.Errorf("event = %q, want prefix %q", event, want)
+		}
+	default:
+		t.Error("no ImageNotFound event recorded")
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/infobloxopen/atlas-app-toolkit/logging"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// explanation traces how a policy decides an image in a namespace
+type explanation struct {
+	Image     string `json:"image"`
+	Namespace string `json:"namespace"`
+	RuleSet   string `json:"ruleSet"`
+	// Rules describe the rules of the rule set, in order
+	Rules    []string     `json:"rules"`
+	Mutate   explainPhase `json:"mutate"`
+	Validate explainPhase `json:"validate"`
+	Outcome  imageOutcome `json:"outcome"`
+}
+
+// explainPhase is the evaluation of every rule by one of the admission controllers
+type explainPhase struct {
+	// Image is the image evaluated, for validation the result of the mutation
+	Image string      `json:"image"`
+	Rules []ruleTrace `json:"rules"`
+}
+
+// explainImage evaluates an image in a namespace like evaluateImage, tracing every rule. Rules
+// after the deciding one are reported as not evaluated, with whether they would match.
+func explainImage(p *Policy, image, namespace string) explanation {
+	p = p.ForNamespace(namespace)
+	traces := map[string]map[int]ruleTrace{"mutate": {}, "validate": {}}
+	traced := p.WithTracer(func(t ruleTrace) {
+		t.Evaluated = true
+		traces[t.Phase][t.Rule] = t
+	})
+	outcome := evaluateImage(traced, image, namespace)
+
+	e := explanation{Image: image, Namespace: namespace, RuleSet: p.RuleSet(), Outcome: outcome}
+	phase := func(name, image string) explainPhase {
+		ph := explainPhase{Image: image}
+		for i, rule := range p.Rules {
+			t, ok := traces[name][i+1]
+			switch {
+			case ok:
+			case name == "validate" && rule.action() == actionRewrite:
+				t = ruleTrace{Phase: name, Rule: i + 1, Skipped: "rewrite rules are not validated"}
+			default:
+				t = ruleTrace{Phase: name, Rule: i + 1, Matched: rule.Match(image)}
+			}
+			ph.Rules = append(ph.Rules, t)
+		}
+		return ph
+	}
+	e.Mutate = phase("mutate", image)
+	e.Validate = phase("validate", outcome.Image)
+	for _, rule := range p.Rules {
+		e.Rules = append(e.Rules, rule.describe())
+	}
+	return e
+}
+
+// describe summarizes a rule, e.g. rewrite pattern (.*) replacement mirror/$1 condition Exists
+func (rule *Pattern) describe() string {
+	parts := []string{rule.action()}
+	for _, field := range []struct{ name, value string }{
+		{"pattern", rule.Pattern},
+		{"registry", rule.Registry},
+		{"repository", rule.Repository},
+		{"tag", rule.Tag},
+		{"digest", rule.Digest},
+		{"replacement", rule.Replacement},
+		{"condition", rule.Condition},
+	} {
+		if field.value != "" {
+			parts = append(parts, field.name+" "+field.value)
+		}
+	}
+	if rule.Constraints != nil {
+		parts = append(parts, "with constraints")
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeExplanation writes an explanation as text
+func writeExplanation(w io.Writer, e explanation) {
+	fmt.Fprintf(w, "%s in namespace %s, evaluated against %s\n", e.Image, e.Namespace, e.RuleSet)
+	for _, ph := range []struct {
+		name  string
+		phase explainPhase
+	}{{"mutate", e.Mutate}, {"validate", e.Validate}} {
+		fmt.Fprintf(w, "\n%s %s\n", ph.name, ph.phase.Image)
+		for i, t := range ph.phase.Rules {
+			fmt.Fprintf(w, "  rule %d %s: %s\n", t.Rule, e.Rules[i], t.describe())
+		}
+	}
+	fmt.Fprintf(w, "\nresult: %s\n", e.Outcome)
+}
+
+// describe summarizes the evaluation of a rule, e.g. match, rewritten to mirror/nginx, exists: found
+func (t ruleTrace) describe() string {
+	if t.Skipped != "" {
+		return "skipped, " + t.Skipped
+	}
+	parts := []string{"no match"}
+	if t.Matched {
+		parts[0] = "match"
+	}
+	if t.Replacement != "" {
+		parts = append(parts, "rewritten to "+t.Replacement)
+	}
+	if t.Exists != "" {
+		parts = append(parts, "exists: "+t.Exists)
+	}
+	if t.Violation != "" {
+		parts = append(parts, "violates constraints: "+t.Violation)
+	}
+	if t.Decided {
+		parts = append(parts, "decided")
+	}
+	if !t.Evaluated {
+		parts = append(parts, "not evaluated")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// runExplain implements tugger explain: every rule is traced for the images in args. It returns
+// the exit code.
+func runExplain(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tugger explain --policy-file FILE [flags] IMAGE...")
+		fmt.Fprintln(stderr, "Lists every rule of the policy in order with how it evaluated the image, and the final decision.")
+		flags.PrintDefaults()
+	}
+	policyFile := flags.String("policy-file", "", "YAML file defining allowed image name patterns (see readme)")
+	namespace := flags.String("namespace", "default", "namespace the image is admitted in")
+	namespaceLabels := flags.String("namespace-labels", "", "labels of the namespace for policy blocks with a namespaceSelector, e.g. env=dev,team=a")
+	output := flags.String("output", "text", "output format: text or json")
+	logLevel := flags.String("log-level", "error", "log verbosity, logs are written to stderr")
+	flags.StringVar(&registryUnreachable, "registry-unreachable", registryUnreachable, "what an unreachable registry means for the Exists condition: missing, exists or deny")
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+	if *policyFile == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return exitError
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "output must be text or json, not %s\n", *output)
+		return exitError
+	}
+	if err := validateRegistryUnreachable(registryUnreachable); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	log = logging.New(*logLevel)
+	log.SetOutput(stderr)
+
+	p, err := NewPolicy(WithConfigFile(*policyFile))
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load policy file %s: %v\n", *policyFile, err)
+		return exitError
+	}
+	nsLabels, err := labels.ConvertSelectorToLabelsMap(*namespaceLabels)
+	if err != nil {
+		fmt.Fprintf(stderr, "invalid --namespace-labels: %v\n", err)
+		return exitError
+	}
+	lister, err := namespaceStub(map[string]map[string]string{*namespace: nsLabels})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	setNamespaceLister(lister)
+	defer setNamespaceLister(nil)
+
+	explanations := []explanation{}
+	for _, image := range flags.Args() {
+		explanations = append(explanations, explainImage(p, image, *namespace))
+	}
+	if *output == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(explanations)
+		return exitOK
+	}
+	for i, e := range explanations {
+		if i > 0 {
+			fmt.Fprintln(stdout)
+		}
+		writeExplanation(stdout, e)
+	}
+	return exitOK
+}
+
+// explainHandler serves the explanation of the image and namespace query parameters as JSON,
+// e.g. /explain?image=nginx&namespace=dev
+func explainHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	image := r.URL.Query().Get("image")
+	if image == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "the image query parameter is required"})
+		return
+	}
+	namespace := r.URL.Query().Get("namespace")
+	if namespace == "" {
+		namespace = "default"
+	}
+	p := currentPolicy()
+	if p == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "no policy file is loaded, images are checked against WHITELIST_REGISTRIES"})
+		return
+	}
+	json.NewEncoder(w).Encode(explainImage(p, image, namespace))
+}
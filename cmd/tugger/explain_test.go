@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestExplainImage(t *testing.T) {
+	p, err := NewPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Load([]byte(policyTestPolicy)); err != nil {
+		t.Fatal(err)
+	}
+	defaultLookup := lookupImage
+	defer func() {
+		lookupImage = defaultLookup
+	}()
+	lookupImage = registryStub{Exists: []string{"jainishshah17/nginx"}}.lookup
+
+	tests := []struct {
+		name         string
+		image        string
+		wantOutcome  imageOutcome
+		wantMutate   []ruleTrace
+		wantValidate []ruleTrace
+	}{
+		{
+			name:        "rewritten",
+			image:       "nginx",
+			wantOutcome: imageOutcome{Expect: expectRewritten, Image: "jainishshah17/nginx", RuleSet: "policy", Rule: 3},
+			wantMutate: []ruleTrace{
+				{Phase: "mutate", Rule: 1, Evaluated: true},
+				{Phase: "mutate", Rule: 2, Evaluated: true},
+				{Phase: "mutate", Rule: 3, Matched: true, Replacement: "jainishshah17/nginx", Exists: "found", Decided: true, Evaluated: true},
+			},
+			wantValidate: []ruleTrace{
+				{Phase: "validate", Rule: 1, Evaluated: true},
+				{Phase: "validate", Rule: 2, Matched: true, Decided: true, Evaluated: true},
+				{Phase: "validate", Rule: 3, Skipped: "rewrite rules are not validated"},
+			},
+		},
+		{
+			name:        "denied",
+			image:       "mysql",
+			wantOutcome: imageOutcome{Expect: expectDenied, Image: "mysql", RuleSet: "policy", Rule: 1, Reason: "use the private mysql image"},
+			wantMutate: []ruleTrace{
+				{Phase: "mutate", Rule: 1, Matched: true, Decided: true, Evaluated: true},
+				{Phase: "mutate", Rule: 2},
+				{Phase: "mutate", Rule: 3, Matched: true},
+			},
+			wantValidate: []ruleTrace{
+				{Phase: "validate", Rule: 1, Matched: true, Decided: true, Evaluated: true},
+				{Phase: "validate", Rule: 2},
+				{Phase: "validate", Rule: 3, Skipped: "rewrite rules are not validated"},
+			},
+		},
+		{
+			name:        "missing",
+			image:       "postgres",
+			wantOutcome: imageOutcome{Expect: expectDenied, Image: "postgres", RuleSet: "policy", Reason: "jainishshah17/postgres does not exist in private registry"},
+			wantMutate: []ruleTrace{
+				{Phase: "mutate", Rule: 1, Evaluated: true},
+				{Phase: "mutate", Rule: 2, Evaluated: true},
+				{Phase: "mutate", Rule: 3, Matched: true, Replacement: "jainishshah17/postgres", Exists: "missing", Evaluated: true},
+			},
+			wantValidate: []ruleTrace{
+				{Phase: "validate", Rule: 1, Evaluated: true},
+				{Phase: "validate", Rule: 2, Evaluated: true},
+				{Phase: "validate", Rule: 3, Skipped: "rewrite rules are not validated"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := explainImage(p, tt.image, "default")
+			if e.Outcome != tt.wantOutcome {
+				t.Errorf("outcome = %+v, want %+v", e.Outcome, tt.wantOutcome)
+			}
+			if !reflect.DeepEqual(e.Mutate.Rules, tt.wantMutate) {
+				t.Errorf("mutate = %+v, want %+v", e.Mutate.Rules, tt.wantMutate)
+			}
+			if !reflect.DeepEqual(e.Validate.Rules, tt.wantValidate) {
+				t.Errorf("validate = %+v, want %+v", e.Validate.Rules, tt.wantValidate)
+			}
+			if e.Validate.Image != tt.wantOutcome.Image {
+				t.Errorf("validated image = %v, want %v", e.Validate.Image, tt.wantOutcome.Image)
+			}
+		})
+	}
+}
+
+func TestRunExplain(t *testing.T) {
+	dir := writeCheckFiles(t, map[string]string{"policy.yaml": policyTestPolicy})
+	policyFile := filepath.Join(dir, "policy.yaml")
+	defaultLog, defaultUnreachable := log, registryUnreachable
+	defer func() {
+		log, registryUnreachable = defaultLog, defaultUnreachable
+	}()
+
+	tests := []struct {
+		name     string
+		args     []string
+		want     []string
+		wantCode int
+	}{
+		{
+			name: "text",
+			args: []string{"--policy-file", policyFile, "mysql"},
+			want: []string{
+				"mysql in namespace default, evaluated against policy",
+				"mutate mysql\n  rule 1 deny pattern ^mysql$: match, decided\n  rule 2 allow pattern ^jainishshah17/.*: no match, not evaluated\n",
+				"  rule 3 rewrite pattern (.*) replacement jainishshah17/$1 condition Exists: skipped, rewrite rules are not validated",
+				"result: denied by rule 1 of policy: use the private mysql image",
+			},
+			wantCode: exitOK,
+		},
+		{
+			name:     "namespace labels",
+			args:     []string{"--policy-file", policyFile, "--namespace", "playground", "--namespace-labels", "env=dev", "postgres"},
+			want:     []string{"evaluated against policy dev", "rule 1 allow pattern .*: match, decided", "result: allowed by rule 1 of policy dev"},
+			wantCode: exitOK,
+		},
+		{
+			name:     "json",
+			args:     []string{"--policy-file", policyFile, "--output", "json", "jainishshah17/app"},
+			want:     []string{`"outcome": "allowed"`, `"ruleSet": "policy"`},
+			wantCode: exitOK,
+		},
+		{
+			name:     "no image",
+			args:     []string{"--policy-file", policyFile},
+			wantCode: exitError,
+		},
+		{
+			name:     "no policy file",
+			args:     []string{"nginx"},
+			wantCode: exitError,
+		},
+		{
+			name:     "invalid namespace labels",
+			args:     []string{"--policy-file", policyFile, "--namespace-labels", "env", "nginx"},
+			wantCode: exitError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := runExplain(tt.args, &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Errorf("runExplain() = %v, want %v, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("output does not contain %q:\n%s", want, stdout.String())
+				}
+			}
+		})
+	}
+	if getNamespaceLister() != nil {
+		t.Error("runExplain() did not restore the namespace lister")
+	}
+}
+
+func TestExplainHandler(t *testing.T) {
+	p, err := NewPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Load([]byte(policyTestPolicy)); err != nil {
+		t.Fatal(err)
+	}
+	defer setPolicy(nil)
+
+	tests := []struct {
+		name       string
+		policy     *Policy
+		url        string
+		wantStatus int
+	}{
+		{name: "explained", policy: p, url: "/explain?image=mysql&namespace=team-a", wantStatus: http.StatusOK},
+		{name: "no image", policy: p, url: "/explain", wantStatus: http.StatusBadRequest},
+		{name: "no policy", url: "/explain?image=mysql", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setPolicy(tt.policy)
+			rec := httptest.NewRecorder()
+			explainHandler(rec, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %v, want %v: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+			e := explanation{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil {
+				t.Fatal(err)
+			}
+			if e.Namespace != "team-a" || e.Outcome.Expect != expectDenied || len(e.Mutate.Rules) != 3 {
+				t.Errorf("explanation = %+v, want mysql denied in team-a", e)
+			}
+		})
+	}
+}
+
+func TestExplainHandler_sideEffects(t *testing.T) {
+	p, err := NewPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Load([]byte(policyTestPolicy)); err != nil {
+		t.Fatal(err)
+	}
+	recorder := &recordingNotifier{}
+	defaultLookup := lookupImage
+	setPolicy(p)
+	notifier = recorder
+	lookupImage = registryStub{Exists: []string{"jainishshah17/nginx"}}.lookup
+	defer func() {
+		setPolicy(nil)
+		notifier = nil
+		lookupImage = defaultLookup
+	}()
+
+	matches := func() float64 {
+		total := 0.0
+		for _, rule := range []string{"1", "2", "3"} {
+			for _, action := range []string{actionAllow, actionDeny, actionRewrite} {
+				total += testutil.ToFloat64(ruleMatches.WithLabelValues("policy", rule, action))
+			}
+		}
+		return total
+	}
+	before := matches()
+	// mysql is denied, nginx rewritten and postgres missing, which notifies when admitted
+	for _, image := range []string{"mysql", "nginx", "postgres"} {
+		rec := httptest.NewRecorder()
+		explainHandler(rec, httptest.NewRequest(http.MethodGet, "/explain?image="+image, nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %v: %s", rec.Code, rec.Body.String())
+		}
+	}
+	if got := matches() - before; got != 0 {
+		t.Errorf("explainHandler() counted %v rule matches, want none", got)
+	}
+	if len(recorder.sent) != 0 {
+		t.Errorf("explainHandler() sent notifications %v, want none", recorder.sent)
+	}
+
+	// the same policy without a tracer notifies, so the test would catch a regression
+	p.mutate("postgres")
+	if len(recorder.sent) != 1 {
+		t.Errorf("mutate() sent %d notifications, want 1", len(recorder.sent))
+	}
+}
@@ -3,13 +3,20 @@ module github.com/jainishshah17/tugger
 go 1.15
 
 require (
+	github.com/evanphx/json-patch v4.12.0+incompatible
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/google/go-containerregistry v0.15.2
+	github.com/google/uuid v1.3.0
 	github.com/infobloxopen/atlas-app-toolkit v1.4.0
 	github.com/jarcoal/httpmock v1.3.0
 	github.com/patrickmn/go-cache v2.1.0+incompatible
+	github.com/prometheus/client_golang v1.14.0
 	github.com/sirupsen/logrus v1.9.3
+	golang.org/x/sync v0.1.0
 	google.golang.org/grpc/examples v0.0.0-20210730002332-ea9b7a0a7651 // indirect
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 	gopkg.in/yaml.v2 v2.4.0
 	k8s.io/api v0.26.2
 	k8s.io/apimachinery v0.26.2
+	k8s.io/client-go v0.26.2
 )
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// dockerHubRegistry is how the Docker Hub registry is named in parsed image references
+const dockerHubRegistry = "docker.io"
+
+// imageRef is an image name split into its normalized components, so that nginx and
+// docker.io/library/nginx:latest have identical references
+type imageRef struct {
+	// Registry is the registry host, docker.io for Docker Hub
+	Registry string
+	// Repository is the repository within the registry, e.g. library/nginx
+	Repository string
+	// Tag is the image tag, latest when the image has neither a tag nor a digest
+	Tag string
+	// Digest is the manifest digest, e.g. sha256:...
+	Digest string
+}
+
+// parseImageRef parses an image name with go-containerregistry and normalizes its components
+func parseImageRef(image string) (imageRef, error) {
+	ref := imageRef{}
+	base := image
+	if i := strings.Index(image, "@"); i >= 0 {
+		digest, err := name.NewDigest(image)
+		if err != nil {
+			return ref, err
+		}
+		ref.Digest = digest.DigestStr()
+		base = image[:i]
+	}
+
+	tag, err := name.NewTag(base)
+	if err != nil {
+		return ref, err
+	}
+	ref.Registry = tag.RegistryStr()
+	if ref.Registry == name.DefaultRegistry {
+		ref.Registry = dockerHubRegistry
+	}
+	ref.Repository = tag.RepositoryStr()
+	if ref.Digest == "" || hasTag(base) {
+		ref.Tag = tag.TagStr()
+	}
+	return ref, nil
+}
+
+// hasTag reports whether an image name without digest has an explicit tag
+func hasTag(image string) bool {
+	last := image[strings.LastIndex(image, "/")+1:]
+	return strings.Contains(last, ":")
+}
+
+// String returns the fully qualified image name
+func (r imageRef) String() string {
+	s := r.Registry + "/" + r.Repository
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// componentMatcher matches one component of an image reference against a regex
+type componentMatcher struct {
+	component string
+	re        *regexp.Regexp
+	negate    bool
+	value     func(imageRef) string
+}
+
+// newComponentMatcher compiles a component expression. The regex must match the whole component,
+// and a leading ! negates it, e.g. !latest matches every tag but latest.
+func newComponentMatcher(component, expr string, value func(imageRef) string) (*componentMatcher, error) {
+	m := &componentMatcher{component: component, value: value}
+	if strings.HasPrefix(expr, "!") {
+		m.negate = true
+		expr = expr[1:]
+	}
+	var err error
+	if m.re, err = regexp.Compile("^(?:" + expr + ")$"); err != nil {
+		return nil, fmt.Errorf("%s: %w", component, err)
+	}
+	return m, nil
+}
+
+// Match reports whether the component of the image reference matches
+func (m *componentMatcher) Match(ref imageRef) bool {
+	return m.re.MatchString(m.value(ref)) != m.negate
+}
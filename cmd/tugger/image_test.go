@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_parseImageRef(t *testing.T) {
+	digest := "sha256:2d4f6d8aec48cf4e5b9a5e9d4e8f5d5c8a4b8f3d3c1e0f4e1a5a8f6b7c9d0e1f"
+	tests := []struct {
+		name    string
+		image   string
+		want    imageRef
+		wantErr bool
+	}{
+		{
+			name:  "docker hub short name",
+			image: "nginx",
+			want:  imageRef{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"},
+		},
+		{
+			name:  "docker hub fully qualified",
+			image: "docker.io/library/nginx:latest",
+			want:  imageRef{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"},
+		},
+		{
+			name:  "docker hub index",
+			image: "index.docker.io/jainishshah17/nginx:1.0",
+			want:  imageRef{Registry: "docker.io", Repository: "jainishshah17/nginx", Tag: "1.0"},
+		},
+		{
+			name:  "registry with port",
+			image: "10.110.50.0:5000/team/nginx:1.19",
+			want:  imageRef{Registry: "10.110.50.0:5000", Repository: "team/nginx", Tag: "1.19"},
+		},
+		{
+			name:  "digest",
+			image: "docker.artifactory.com/nginx@" + digest,
+			want:  imageRef{Registry: "docker.artifactory.com", Repository: "nginx", Digest: digest},
+		},
+		{
+			name:  "tag and digest",
+			image: "docker.artifactory.com/nginx:1.19@" + digest,
+			want:  imageRef{Registry: "docker.artifactory.com", Repository: "nginx", Tag: "1.19", Digest: digest},
+		},
+		{
+			name:    "invalid",
+			image:   "Nginx:%",
+			wantErr: true,
+		},
+		{
+			name:    "invalid digest",
+			image:   "nginx@sha256:short",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseImageRef(tt.image)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseImageRef() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want && !tt.wantErr {
+				t.Errorf("parseImageRef() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_componentMatcher_Match(t *testing.T) {
+	ref := imageRef{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"}
+	tag := func(r imageRef) string { return r.Tag }
+	tests := []struct {
+		name    string
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		{name: "exact", expr: "latest", want: true},
+		{name: "anchored", expr: "late", want: false},
+		{name: "alternation", expr: "1.19|latest", want: true},
+		{name: "negated", expr: "!latest", want: false},
+		{name: "negated mismatch", expr: "!1.19", want: true},
+		{name: "invalid", expr: "(", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := newComponentMatcher("tag", tt.expr, tag)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newComponentMatcher() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := m.Match(ref); got != tt.want {
+				t.Errorf("componentMatcher.Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
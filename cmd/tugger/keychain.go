@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// podPullSecrets makes registry lookups authenticate with the pull secrets of the admitted pod
+var podPullSecrets = true
+
+// pullSecretKeychain resolves registry credentials from image pull secrets, in order: the pod's
+// imagePullSecrets, REGISTRY_SECRET_NAME and the pull secrets of the pod's service account.
+// The secrets are read on the first lookup, so admissions without lookups don't read them.
+type pullSecretKeychain struct {
+	client         kubernetes.Interface
+	namespace      string
+	serviceAccount string
+	secretNames    []string
+
+	once    sync.Once
+	configs []dockerConfig
+}
+
+// dockerConfig maps registries to credentials, as in a kubernetes.io/dockerconfigjson secret
+type dockerConfig map[string]authn.AuthConfig
+
+// podKeychain returns the keychain for registry lookups of a pod's images, or nil to use the
+// default keychain when pull secrets can not be read
+func podKeychain(namespace string, spec *v1.PodSpec) authn.Keychain {
+	if !podPullSecrets {
+		return nil
+	}
+	client, err := getKubeClient()
+	if err != nil {
+		log.WithError(err).Debug("could not create kubernetes client, registry lookups will not use pull secrets")
+		return nil
+	}
+	secretNames := []string{}
+	for _, secret := range spec.ImagePullSecrets {
+		secretNames = append(secretNames, secret.Name)
+	}
+	if registrySecretName != "" {
+		secretNames = append(secretNames, registrySecretName)
+	}
+	return newPullSecretKeychain(client, namespace, spec.ServiceAccountName, secretNames)
+}
+
+// newPullSecretKeychain creates a keychain for the named secrets and service account in a namespace
+func newPullSecretKeychain(client kubernetes.Interface, namespace, serviceAccount string, secretNames []string) *pullSecretKeychain {
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+	return &pullSecretKeychain{
+		client:         client,
+		namespace:      namespace,
+		serviceAccount: serviceAccount,
+		secretNames:    secretNames,
+	}
+}
+
+// id identifies the credentials of the keychain, so cached lookups are not shared between pods
+// that see different registries
+func (k *pullSecretKeychain) id() string {
+	return fmt.Sprintf("%s/%s/%s", k.namespace, k.serviceAccount, strings.Join(k.secretNames, ","))
+}
+
+// Resolve returns the credentials of the first pull secret with an entry for the target registry
+func (k *pullSecretKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	k.once.Do(k.load)
+	registry := normalizeRegistryHost(target.RegistryStr())
+	for _, config := range k.configs {
+		if auth, ok := config.lookup(registry); ok {
+			return authn.FromConfig(auth), nil
+		}
+	}
+	return authn.Anonymous, nil
+}
+
+// load reads the pull secrets, skipping secrets that can not be read or parsed
+func (k *pullSecretKeychain) load() {
+	ctx, cancel := registryContext()
+	defer cancel()
+
+	names := append([]string{}, k.secretNames...)
+	sa, err := k.client.CoreV1().ServiceAccounts(k.namespace).Get(ctx, k.serviceAccount, metav1.GetOptions{})
+	if err != nil {
+		log.WithError(err).WithField("service-account", k.namespace+"/"+k.serviceAccount).Warn("could not get service account pull secrets")
+	} else {
+		for _, secret := range sa.ImagePullSecrets {
+			names = append(names, secret.Name)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		secret, err := k.client.CoreV1().Secrets(k.namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			log.WithError(err).WithField("secret", k.namespace+"/"+name).Warn("could not get pull secret")
+			continue
+		}
+		config, err := parseDockerConfig(secret)
+		if err != nil {
+			log.WithError(err).WithField("secret", k.namespace+"/"+name).Warn("could not parse pull secret")
+			continue
+		}
+		k.configs = append(k.configs, config)
+	}
+}
+
+// parseDockerConfig reads the registry credentials of a kubernetes.io/dockerconfigjson or
+// kubernetes.io/dockercfg secret
+func parseDockerConfig(secret *v1.Secret) (dockerConfig, error) {
+	if data, ok := secret.Data[v1.DockerConfigJsonKey]; ok {
+		config := struct {
+			Auths dockerConfig `json:"auths"`
+		}{}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+		return config.Auths, nil
+	}
+	if data, ok := secret.Data[v1.DockerConfigKey]; ok {
+		config := dockerConfig{}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return nil, err
+		}
+		return config, nil
+	}
+	return nil, fmt.Errorf("secret has neither %s nor %s", v1.DockerConfigJsonKey, v1.DockerConfigKey)
+}
+
+// lookup returns the credentials for a registry host. Entries may be URLs such as
+// https://index.docker.io/v1/, and globs such as *.artifactory.com, like the kubelet accepts.
+func (c dockerConfig) lookup(registry string) (authn.AuthConfig, bool) {
+	for entry, auth := range c {
+		if dockerConfigHost(entry) == registry {
+			return auth, true
+		}
+	}
+	for entry, auth := range c {
+		if ok, _ := path.Match(dockerConfigHost(entry), registry); ok {
+			return auth, true
+		}
+	}
+	return authn.AuthConfig{}, false
+}
+
+// dockerConfigHost returns the registry host of a docker config entry
+func dockerConfigHost(entry string) string {
+	if strings.Contains(entry, "://") {
+		if u, err := url.Parse(entry); err == nil {
+			entry = u.Host
+		}
+	}
+	return normalizeRegistryHost(strings.SplitN(entry, "/", 2)[0])
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// dockerConfigJSONSecret returns a kubernetes.io/dockerconfigjson secret with credentials for a registry
+func dockerConfigJSONSecret(name, registry, username, password string) *v1.Secret {
+	auth := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "foobar"},
+		Type:       v1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			v1.DockerConfigJsonKey: []byte(`{"auths":{"` + registry + `":{"auth":"` + auth + `"}}}`),
+		},
+	}
+}
+
+func Test_pullSecretKeychain_Resolve(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		dockerConfigJSONSecret("pod", "docker.artifactory.com", "pod", "secret"),
+		dockerConfigJSONSecret("registry", "https://docker.artifactory.com/v2/", "registry", "secret"),
+		dockerConfigJSONSecret("glob", "*.artifactory.com", "glob", "secret"),
+		dockerConfigJSONSecret("hub", "https://index.docker.io/v1/", "hub", "secret"),
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "legacy", Namespace: "foobar"},
+			Type:       v1.SecretTypeDockercfg,
+			Data: map[string][]byte{
+				v1.DockerConfigKey: []byte(`{"quay.io":{"username":"legacy","password":"secret"}}`),
+			},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: "foobar"},
+			Data:       map[string][]byte{"token": []byte("secret")},
+		},
+		&v1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: "default", Namespace: "foobar"},
+			ImagePullSecrets: []v1.LocalObjectReference{{Name: "glob"}, {Name: "hub"}, {Name: "legacy"}},
+		},
+	)
+	tests := []struct {
+		name         string
+		secretNames  []string
+		image        string
+		wantUsername string
+	}{
+		{
+			name:         "pod secret first",
+			secretNames:  []string{"pod", "registry"},
+			image:        "docker.artifactory.com/nginx",
+			wantUsername: "pod",
+		},
+		{
+			name:         "registry secret url",
+			secretNames:  []string{"missing", "opaque", "registry"},
+			image:        "docker.artifactory.com/nginx",
+			wantUsername: "registry",
+		},
+		{
+			name:         "service account glob",
+			image:        "eu.artifactory.com/nginx",
+			wantUsername: "glob",
+		},
+		{
+			name:         "docker hub",
+			image:        "nginx",
+			wantUsername: "hub",
+		},
+		{
+			name:         "dockercfg",
+			image:        "quay.io/jainishshah17/nginx",
+			wantUsername: "legacy",
+		},
+		{
+			name:  "anonymous",
+			image: "gcr.io/jainishshah17/nginx",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ref, err := name.ParseReference(tt.image)
+			if err != nil {
+				t.Fatal(err)
+			}
+			k := newPullSecretKeychain(client, "foobar", "", tt.secretNames)
+			auth, err := k.Resolve(ref.Context())
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := auth.Authorization()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Username != tt.wantUsername {
+				t.Errorf("pullSecretKeychain.Resolve() username = %q, want %q", config.Username, tt.wantUsername)
+			}
+		})
+	}
+}
+
+func Test_imageExists_pullSecrets(t *testing.T) {
+	reg := registry.New()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "tugger" || password != "secret" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := name.NewTag(host + "/private/nginx:1.19")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(ref, img, remote.WithAuth(&authn.Basic{Username: "tugger", Password: "secret"})); err != nil {
+		t.Fatal(err)
+	}
+
+	client := fake.NewSimpleClientset(dockerConfigJSONSecret("pull", host, "tugger", "secret"))
+	if exists, _ := imageExists(ref.String(), nil); exists {
+		t.Error("imageExists() without pull secrets = true, want false")
+	}
+	if exists, _ := imageExists(ref.String(), newPullSecretKeychain(client, "foobar", "", []string{"pull"})); !exists {
+		t.Error("imageExists() with pull secrets = false, want true")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	kubeClientOnce sync.Once
+	kubeClient     kubernetes.Interface
+	kubeClientErr  error
+
+	namespaceInformerOnce sync.Once
+	namespaceListerMu     sync.RWMutex
+	namespaceLister       corev1listers.NamespaceLister
+	// namespaceSynced is closed once the namespace informer has synced
+	namespaceSynced chan struct{}
+
+	// namespaceSyncTimeout bounds how long admission waits for the namespace informer to sync
+	namespaceSyncTimeout = 30 * time.Second
+)
+
+// getKubeClient returns a client for the cluster tugger runs in
+func getKubeClient() (kubernetes.Interface, error) {
+	kubeClientOnce.Do(func() {
+		config, err := rest.InClusterConfig()
+		if err != nil {
+			kubeClientErr = err
+			return
+		}
+		kubeClient, kubeClientErr = kubernetes.NewForConfig(config)
+	})
+	return kubeClient, kubeClientErr
+}
+
+// startNamespaceInformer starts caching namespaces, so policy blocks can select them by label.
+// It only runs once, and only needs to run when a policy uses a namespaceSelector.
+func startNamespaceInformer() {
+	namespaceInformerOnce.Do(func() {
+		client, err := getKubeClient()
+		if err != nil {
+			log.WithError(err).Error("could not create kubernetes client, namespaceSelector will not match any namespace")
+			return
+		}
+		factory := informers.NewSharedInformerFactory(client, 10*time.Minute)
+		synced := make(chan struct{})
+		setNamespaceLister(factory.Core().V1().Namespaces().Lister())
+		namespaceListerMu.Lock()
+		namespaceSynced = synced
+		namespaceListerMu.Unlock()
+		factory.Start(wait.NeverStop)
+		go func() {
+			factory.WaitForCacheSync(wait.NeverStop)
+			log.Info("namespace informer synced")
+			close(synced)
+		}()
+	})
+}
+
+// waitForNamespaceSync waits up to timeout for the namespace informer to sync and reports whether
+// it did. Until then namespaceSelector matches no namespace, so it is called before the policy is
+// applied.
+func waitForNamespaceSync(timeout time.Duration) bool {
+	namespaceListerMu.RLock()
+	synced := namespaceSynced
+	namespaceListerMu.RUnlock()
+	if synced == nil {
+		return false
+	}
+	select {
+	case <-synced:
+		return true
+	case <-time.After(timeout):
+		log.WithField("timeout", timeout).Warn("namespace informer did not sync, namespaceSelector will not match namespaces until it does")
+		return false
+	}
+}
+
+func setNamespaceLister(lister corev1listers.NamespaceLister) {
+	namespaceListerMu.Lock()
+	defer namespaceListerMu.Unlock()
+	namespaceLister = lister
+}
+
+// getNamespaceLister returns the namespace lister, or nil when namespaces are not cached
+func getNamespaceLister() corev1listers.NamespaceLister {
+	namespaceListerMu.RLock()
+	defer namespaceListerMu.RUnlock()
+	return namespaceLister
+}
+
+// namespaceLabels returns the labels of a namespace, or an empty set when they can not be resolved
+func namespaceLabels(name string) labels.Set {
+	lister := getNamespaceLister()
+	if lister == nil {
+		return labels.Set{}
+	}
+	ns, err := lister.Get(name)
+	if err != nil {
+		log.WithError(err).WithField("namespace", name).Warn("could not get namespace labels, policy blocks with a namespaceSelector will not match it")
+		return labels.Set{}
+	}
+	return labels.Set(ns.Labels)
+}
+
+// namespaceUID returns the UID of a cached namespace, or an empty UID when it can not be resolved
+func namespaceUID(name string) types.UID {
+	lister := getNamespaceLister()
+	if lister == nil {
+		return ""
+	}
+	ns, err := lister.Get(name)
+	if err != nil {
+		return ""
+	}
+	return ns.UID
+}
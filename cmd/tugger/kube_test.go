@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// newFakeNamespaceLister returns a lister serving namespaces with the given labels
+func newFakeNamespaceLister(t *testing.T, namespaces map[string]map[string]string) corev1listers.NamespaceLister {
+	t.Helper()
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, nsLabels := range namespaces {
+		ns := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nsLabels}}
+		if err := indexer.Add(ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return corev1listers.NewNamespaceLister(indexer)
+}
+
+func Test_namespaceLabels(t *testing.T) {
+	tests := []struct {
+		name      string
+		lister    bool
+		namespace string
+		want      labels.Set
+	}{
+		{
+			name:      "happy",
+			lister:    true,
+			namespace: "dev-1",
+			want:      labels.Set{"env": "dev"},
+		},
+		{
+			name:      "not found",
+			lister:    true,
+			namespace: "prod",
+			want:      labels.Set{},
+		},
+		{
+			name:      "no informer",
+			namespace: "dev-1",
+			want:      labels.Set{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.lister {
+				setNamespaceLister(newFakeNamespaceLister(t, map[string]map[string]string{
+					"dev-1": {"env": "dev"},
+				}))
+				defer setNamespaceLister(nil)
+			}
+			if got := namespaceLabels(tt.namespace); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("namespaceLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_waitForNamespaceSync(t *testing.T) {
+	defer func() {
+		namespaceSynced = nil
+	}()
+	if waitForNamespaceSync(time.Millisecond) {
+		t.Error("waitForNamespaceSync() = true without an informer, want false")
+	}
+	namespaceSynced = make(chan struct{})
+	if waitForNamespaceSync(time.Millisecond) {
+		t.Error("waitForNamespaceSync() = true before the informer synced, want false")
+	}
+	close(namespaceSynced)
+	if !waitForNamespaceSync(time.Minute) {
+		t.Error("waitForNamespaceSync() = false after the informer synced, want true")
+	}
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/infobloxopen/atlas-app-toolkit/logging"
+)
+
+// Lint checks, see lintRuleSet
+const (
+	// lintShadowed is a rule that never decides an image because an earlier rule always does
+	lintShadowed = "shadowed"
+	// lintUnanchored is a pattern that also matches image names containing the intended ones
+	lintUnanchored = "unanchored"
+	// lintCaptureGroup is a replacement referring to a capture group the pattern does not have
+	lintCaptureGroup = "capture-group"
+	// lintMutationLoop is a rewrite target the mutating admission controller rewrites again or denies
+	lintMutationLoop = "mutation-loop"
+	// lintRejectedRewrite is a rewrite target the validating admission controller rejects
+	lintRejectedRewrite = "rejected-rewrite"
+)
+
+// lintProbes are image names tried against every rewrite rule, besides names generated from its pattern
+var lintProbes = []string{"nginx", "library/nginx:1.19", "docker.io/library/nginx:latest", "gcr.io/project/app:v1"}
+
+// lintFinding is a likely mistake in a rule of a policy
+type lintFinding struct {
+	RuleSet string `json:"ruleSet"`
+	Rule    int    `json:"rule"`
+	Check   string `json:"check"`
+	Message string `json:"message"`
+}
+
+// String describes the finding, e.g. policy dev, rule 2: shadowed: ...
+func (f lintFinding) String() string {
+	return fmt.Sprintf("%s, rule %d: %s: %s", f.RuleSet, f.Rule, f.Check, f.Message)
+}
+
+// lintPolicy checks the rules of a policy and of each of its policy blocks. Rewrite targets are
+// evaluated assuming that the Exists condition is met, without registry lookups.
+func lintPolicy(p *Policy) []lintFinding {
+	defaultLookup, defaultCache := lookupImage, existsCache
+	defer func() {
+		lookupImage, existsCache = defaultLookup, defaultCache
+	}()
+	lookupImage = func(string, authn.Keychain) imageStatus {
+		return imageFound
+	}
+	existsCache = nil
+
+	// traced policies do not count rule matches or send notifications
+	noop := func(ruleTrace) {}
+	findings := lintRuleSet(p.WithTracer(noop))
+	for _, block := range p.Policies {
+		findings = append(findings, lintRuleSet(block.WithTracer(noop))...)
+	}
+	return findings
+}
+
+// lintRuleSet checks each rule of a rule set for shadowing by earlier rules, unanchored patterns,
+// replacements referring to missing capture groups, and rewrite targets that the mutating
+// admission controller would not leave alone or that the validating one would reject
+func lintRuleSet(p *Policy) []lintFinding {
+	findings := []lintFinding{}
+	add := func(i int, check, format string, args ...interface{}) {
+		findings = append(findings, lintFinding{RuleSet: p.RuleSet(), Rule: i + 1, Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for i, rule := range p.Rules {
+		// the pattern compiled, so it parses
+		re, _ := syntax.Parse(rule.re.String(), syntax.Perl)
+
+		for j, earlier := range p.Rules[:i] {
+			if reason := earlier.shadows(rule); reason != "" {
+				add(i, lintShadowed, "rule %d %s, so this rule never decides an image", j+1, reason)
+				break
+			}
+		}
+
+		if rule.Pattern != "" && !matchesEverything(re) {
+			samples := rule.samples(re)
+			if !anchoredStart(re) && len(samples) > 0 {
+				add(i, lintUnanchored, "pattern %s is not anchored with ^, so it also matches names containing the intended ones, e.g. %s", rule.Pattern, "evil.io/"+samples[0])
+			} else if !anchoredEnd(re) && len(samples) > 0 {
+				add(i, lintUnanchored, "pattern %s is not anchored with $, so it also matches names continuing after the intended ones, e.g. %s", rule.Pattern, samples[0]+"-evil")
+			}
+		}
+
+		if rule.action() != actionRewrite {
+			continue
+		}
+		for _, group := range replacementGroups(rule.Replacement) {
+			if n, err := strconv.Atoi(group); err == nil {
+				if n > rule.re.NumSubexp() {
+					groups := fmt.Sprintf("%d capture groups", rule.re.NumSubexp())
+					if rule.re.NumSubexp() == 1 {
+						groups = "1 capture group"
+					}
+					add(i, lintCaptureGroup, "replacement %s refers to $%d, but the pattern has %s", rule.Replacement, n, groups)
+				}
+			} else if rule.re.SubexpIndex(group) < 0 {
+				hint := ""
+				if digits := len(group) - len(strings.TrimLeft(group, "0123456789")); digits > 0 {
+					hint = fmt.Sprintf(", write ${%s}%s to follow a group by text", group[:digits], group[digits:])
+				}
+				add(i, lintCaptureGroup, "replacement %s refers to a capture group named %s, which the pattern does not have%s", rule.Replacement, group, hint)
+			}
+		}
+		findings = append(findings, lintRewrite(p, i)...)
+	}
+	return findings
+}
+
+// lintRewrite checks the images a rewrite rule produces from names that reach it: the mutating
+// admission controller must leave them as they are when it is invoked again, and the validating
+// admission controller must admit them
+func lintRewrite(p *Policy, i int) []lintFinding {
+	rule := p.Rules[i]
+	re, _ := syntax.Parse(rule.re.String(), syntax.Perl)
+	findings := []lintFinding{}
+	add := func(check, format string, args ...interface{}) {
+		findings = append(findings, lintFinding{RuleSet: p.RuleSet(), Rule: i + 1, Check: check, Message: fmt.Sprintf(format, args...)})
+	}
+
+	loop, rejected := false, false
+	for _, image := range append(append([]string{}, lintProbes...), rule.samples(re)...) {
+		if loop && rejected {
+			break
+		}
+		if d := p.mutate(image); !d.allowed || d.rule != i+1 {
+			// another rule decides the image before this one
+			continue
+		}
+		target := rule.re.ReplaceAllString(image, rule.Replacement)
+		again, validated := p.mutate(target), p.validate(target)
+		// constraints depend on the tag of the original image, not on the rewrite
+		isRejected := !validated.allowed && (validated.rule == 0 || p.Rules[validated.rule-1].action() == actionDeny)
+
+		if !rejected && isRejected {
+			rejected = true
+			add(lintRejectedRewrite, "rewrites %s to %s, which the validating webhook rejects: %s", image, target, ruleSetReason(validated))
+		}
+		if loop {
+			continue
+		}
+		switch {
+		case again.allowed && again.image != target:
+			loop = true
+			add(lintMutationLoop, "rewrites %s to %s, which rule %d rewrites again to %s", image, target, again.rule, again.image)
+		case !again.allowed && !isRejected:
+			// a denial in validation is reported as a rejected rewrite
+			loop = true
+			add(lintMutationLoop, "rewrites %s to %s, which the policy denies when the mutating webhook is invoked again: %s", image, target, ruleSetReason(again))
+		}
+	}
+	return findings
+}
+
+// ruleSetReason describes why a decision denies an image
+func ruleSetReason(d decision) string {
+	if d.rule == 0 {
+		return d.reason
+	}
+	return fmt.Sprintf("rule %d: %s", d.rule, d.reason)
+}
+
+// catchAll reports whether the rule matches every image name and decides it in validation
+func (rule *Pattern) catchAll() bool {
+	if len(rule.components) > 0 || rule.Condition == "Exists" {
+		return false
+	}
+	re, err := syntax.Parse(rule.re.String(), syntax.Perl)
+	return err == nil && matchesEverything(re)
+}
+
+// shadows returns why the rule always decides the images of a later one, or an empty string.
+// Rewrite rules do not take part in validation, so they only shadow later rewrite rules.
+func (rule *Pattern) shadows(later *Pattern) string {
+	if rule.Condition == "Exists" || (rule.action() == actionRewrite && later.action() != actionRewrite) {
+		return ""
+	}
+	if rule.catchAll() {
+		return fmt.Sprintf("is a catch-all %s rule", rule.action())
+	}
+	if rule.Pattern == later.Pattern && rule.Registry == later.Registry && rule.Repository == later.Repository &&
+		rule.Tag == later.Tag && rule.Digest == later.Digest {
+		return fmt.Sprintf("is a %s rule matching the same images", rule.action())
+	}
+	return ""
+}
+
+// matchesEverything reports whether a regex matches every non-empty string: it consists of anchors,
+// optional parts and .* or .+, or of anchors and optional parts without being anchored at both ends
+func matchesEverything(re *syntax.Regexp) bool {
+	parts := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		parts = re.Sub
+	}
+	begin, end, wildcard := false, false, false
+	for _, part := range parts {
+		for part.Op == syntax.OpCapture {
+			part = part.Sub[0]
+		}
+		switch part.Op {
+		case syntax.OpBeginText, syntax.OpBeginLine:
+			begin = true
+		case syntax.OpEndText, syntax.OpEndLine:
+			end = true
+		case syntax.OpEmptyMatch, syntax.OpQuest:
+		case syntax.OpStar, syntax.OpPlus:
+			if op := part.Sub[0].Op; op == syntax.OpAnyChar || op == syntax.OpAnyCharNotNL {
+				wildcard = true
+			} else if part.Op == syntax.OpPlus {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return wildcard || !(begin && end)
+}
+
+// anchoredStart reports whether a regex only matches at the start of a string
+func anchoredStart(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpBeginText, syntax.OpBeginLine:
+		return true
+	case syntax.OpCapture:
+		return anchoredStart(re.Sub[0])
+	case syntax.OpConcat:
+		return len(re.Sub) > 0 && anchoredStart(re.Sub[0])
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !anchoredStart(sub) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// anchoredEnd reports whether a regex matches up to the end of a string, or ends in a literal that
+// is meant as a prefix, e.g. a repository ending in / or a name ending in :
+func anchoredEnd(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return len(re.Rune) > 0 && strings.ContainsRune("/:@", re.Rune[len(re.Rune)-1])
+	case syntax.OpCapture:
+		return anchoredEnd(re.Sub[0])
+	case syntax.OpConcat:
+		return len(re.Sub) > 0 && anchoredEnd(re.Sub[len(re.Sub)-1])
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !anchoredEnd(sub) {
+				return false
+			}
+		}
+		return true
+	}
+	return true
+}
+
+// samples returns image names the rule matches, generated from its pattern
+func (rule *Pattern) samples(re *syntax.Regexp) []string {
+	samples := []string{}
+	for _, sample := range regexSamples(re, 8) {
+		if sample != "" && rule.Match(sample) {
+			samples = append(samples, sample)
+		}
+	}
+	return samples
+}
+
+// regexSamples returns up to limit strings a regex matches: repetitions are expanded once,
+// character classes to a single character and alternations to each alternative
+func regexSamples(re *syntax.Regexp, limit int) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return []string{string(re.Rune)}
+	case syntax.OpCharClass:
+		// an empty class such as [^\x00-\x{10FFFF}] matches nothing
+		if len(re.Rune) == 0 {
+			return []string{}
+		}
+		r := re.Rune[0]
+		for k := 0; k+1 < len(re.Rune); k += 2 {
+			if re.Rune[k] <= 'a' && 'a' <= re.Rune[k+1] {
+				r = 'a'
+			}
+		}
+		return []string{string(r)}
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return []string{"a"}
+	case syntax.OpCapture, syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+		return regexSamples(re.Sub[0], limit)
+	case syntax.OpRepeat:
+		if re.Max == 0 {
+			return []string{""}
+		}
+		n := re.Min
+		if n == 0 {
+			n = 1
+		}
+		subs := make([]*syntax.Regexp, n)
+		for k := range subs {
+			subs[k] = re.Sub[0]
+		}
+		return regexSamples(&syntax.Regexp{Op: syntax.OpConcat, Sub: subs}, limit)
+	case syntax.OpConcat:
+		samples := []string{""}
+		for _, sub := range re.Sub {
+			next := []string{}
+			for _, prefix := range samples {
+				for _, s := range regexSamples(sub, limit) {
+					if len(next) < limit {
+						next = append(next, prefix+s)
+					}
+				}
+			}
+			samples = next
+		}
+		return samples
+	case syntax.OpAlternate:
+		samples := []string{}
+		for _, sub := range re.Sub {
+			for _, s := range regexSamples(sub, limit) {
+				if len(samples) < limit {
+					samples = append(samples, s)
+				}
+			}
+		}
+		return samples
+	}
+	return []string{""}
+}
+
+// replacementGroups returns the capture groups a replacement refers to with $1, ${1}, $name or
+// ${name}, parsed like regexp.Expand does, so $1x refers to a group named 1x
+func replacementGroups(replacement string) []string {
+	groups := []string{}
+	for i := 0; i < len(replacement)-1; i++ {
+		if replacement[i] != '$' {
+			continue
+		}
+		rest := replacement[i+1:]
+		if rest[0] == '$' {
+			i++
+			continue
+		}
+		var name string
+		if rest[0] == '{' {
+			end := strings.IndexByte(rest, '}')
+			if end < 0 {
+				continue
+			}
+			name = rest[1:end]
+			i += end + 1
+		} else {
+			n := 0
+			for n < len(rest) && isGroupNameByte(rest[n]) {
+				n++
+			}
+			name = rest[:n]
+			i += n
+		}
+		if name != "" {
+			groups = append(groups, name)
+		}
+	}
+	return groups
+}
+
+// isGroupNameByte reports whether a byte can be part of a capture group name in a replacement
+func isGroupNameByte(b byte) bool {
+	return b == '_' || ('0' <= b && b <= '9') || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z')
+}
+
+// runLint implements tugger lint: the policy files in args are checked for likely mistakes. It
+// returns the exit code.
+func runLint(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tugger lint [flags] POLICY_FILE...")
+		fmt.Fprintln(stderr, "Reports shadowed rules, unanchored patterns, missing capture groups and rewrites the policy does not accept.")
+		fmt.Fprintln(stderr, "Exits 1 when a policy has findings and 2 when a policy file can not be loaded.")
+		flags.PrintDefaults()
+	}
+	output := flags.String("output", "text", "output format: text or json")
+	logLevel := flags.String("log-level", "error", "log verbosity, logs are written to stderr")
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitError
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(stderr, "output must be text or json, not %s\n", *output)
+		return exitError
+	}
+
+	log = logging.New(*logLevel)
+	log.SetOutput(stderr)
+
+	type fileFindings struct {
+		File     string        `json:"file"`
+		Findings []lintFinding `json:"findings"`
+	}
+	results := []fileFindings{}
+	code := exitOK
+	for _, file := range flags.Args() {
+		p, err := NewPolicy(WithConfigFile(file))
+		if err != nil {
+			fmt.Fprintf(stderr, "failed to load policy file %s: %v\n", file, err)
+			return exitError
+		}
+		findings := lintPolicy(p)
+		if len(findings) > 0 {
+			code = exitFailure
+		}
+		results = append(results, fileFindings{File: file, Findings: findings})
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return code
+	}
+	for _, result := range results {
+		for _, finding := range result.Findings {
+			fmt.Fprintf(stdout, "%s: %s\n", result.File, finding)
+		}
+	}
+	return code
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"regexp/syntax"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLintPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		// want are the findings as rule set, rule and check
+		want []string
+	}{
+		{
+			name: "clean",
+			policy: `
+rules:
+- pattern: ^mysql$
+  action: deny
+- pattern: ^jainishshah17/.*
+  constraints:
+    forbidLatest: true
+- pattern: ^(.*)$
+  replacement: jainishshah17/$1
+  condition: Exists
+- registry: gcr.io
+`,
+			want: []string{},
+		},
+		{
+			name: "shadowed",
+			policy: `
+rules:
+- pattern: ^mysql$
+  action: deny
+- pattern: ^mysql$
+- pattern: .*
+  replacement: mirror/$0
+  condition: Exists
+- pattern: (.*)
+  replacement: jainishshah17/$1
+- pattern: ^(.*)$
+  replacement: other/$1
+- pattern: ^jainishshah17/.*
+- pattern: .*
+- pattern: ^foo$
+`,
+			want: []string{
+				"policy 2 shadowed",
+				"policy 3 mutation-loop",
+				"policy 5 shadowed",
+				"policy 8 shadowed",
+			},
+		},
+		{
+			name: "unanchored",
+			policy: `
+rules:
+- pattern: jainishshah17/.*
+- pattern: ^nginx
+- pattern: "^redis:"
+- pattern: ^(mysql|postgres)$
+- pattern: ^mysql$|^postgres$
+- pattern: ^mysql$|postgres
+`,
+			want: []string{
+				"policy 1 unanchored",
+				"policy 2 unanchored",
+				"policy 6 unanchored",
+			},
+		},
+		{
+			name: "capture groups",
+			policy: `
+rules:
+- pattern: ^jainishshah17/.*
+- pattern: ^(.*)$
+  replacement: jainishshah17/$1x
+  condition: Exists
+- pattern: ^(?P<name>.*)$
+  replacement: jainishshah17/${name}$$
+  condition: Exists
+- pattern: ^(.*):(.*)$
+  replacement: jainishshah17/$1:${3}
+  condition: Exists
+- pattern: ^(.*)$
+  replacement: jainishshah17/$image
+`,
+			want: []string{
+				"policy 2 capture-group",
+				"policy 4 capture-group",
+				"policy 5 capture-group",
+			},
+		},
+		{
+			name: "mutation loop",
+			policy: `
+rules:
+- pattern: ^(.*)$
+  replacement: jainishshah17/$1
+- pattern: .*
+`,
+			want: []string{"policy 1 mutation-loop"},
+		},
+		{
+			name: "rewrite denied",
+			policy: `
+rules:
+- pattern: ^mirror/.*
+  action: deny
+- pattern: ^docker.io/(.*)$
+  replacement: mirror/$1
+- pattern: .*
+`,
+			want: []string{"policy 2 rejected-rewrite"},
+		},
+		{
+			name: "rewrite not allowed",
+			policy: `
+rules:
+- pattern: ^gcr.io/.*
+- pattern: ^(nginx|redis)$
+  replacement: mirror/$1
+  condition: Exists
+`,
+			want: []string{"policy 2 rejected-rewrite"},
+		},
+		{
+			name: "policy blocks",
+			policy: `
+rules:
+- pattern: .*
+policies:
+- name: dev
+  namespaces: [dev]
+  rules:
+  - pattern: .*
+  - pattern: ^nginx$
+`,
+			want: []string{"policy dev 2 shadowed"},
+		},
+		{
+			name: "empty character class",
+			policy: `
+rules:
+- pattern: ^a[^\x00-\x{10FFFF}]
+- pattern: ^jainishshah17/.*
+`,
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPolicy()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Load([]byte(tt.policy)); err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, f := range lintPolicy(p) {
+				got = append(got, strings.Join([]string{f.RuleSet, strconv.Itoa(f.Rule), f.Check}, " "))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lintPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if lookupImage == nil {
+		t.Error("lintPolicy() did not restore the registry lookup")
+	}
+}
+
+func TestReplacementGroups(t *testing.T) {
+	tests := []struct {
+		replacement string
+		want        []string
+	}{
+		{"mirror/$1", []string{"1"}},
+		{"mirror/${1}x", []string{"1"}},
+		{"mirror/$1x", []string{"1x"}},
+		{"$registry/${repo}:$tag", []string{"registry", "repo", "tag"}},
+		{"price$$1", []string{}},
+		{"trailing$", []string{}},
+		{"unclosed${1", []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.replacement, func(t *testing.T) {
+			if got := replacementGroups(tt.replacement); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("replacementGroups() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchesEverything(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    bool
+	}{
+		{"", true},
+		{".*", true},
+		{"(.*)", true},
+		{"^(.*)$", true},
+		{"^.+$", true},
+		{"^", true},
+		{"^$", false},
+		{"(?:jainishshah17)?(.*)", true},
+		{"^a.*", false},
+		{"^a?$", false},
+		{"^[a-z]*$", false},
+		{"^[a-z]+.*", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			re, err := syntax.Parse(tt.pattern, syntax.Perl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matchesEverything(re); got != tt.want {
+				t.Errorf("matchesEverything() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunLint(t *testing.T) {
+	dir := writeCheckFiles(t, map[string]string{
+		"clean.yaml":   policyTestPolicy,
+		"loop.yaml":    "rules:\n- pattern: ^(.*)$\n  replacement: mirror/$1\n- pattern: .*\n",
+		"invalid.yaml": "rules:\n- pattern: (\n",
+	})
+	defaultLog := log
+	defer func() {
+		log = defaultLog
+	}()
+
+	tests := []struct {
+		name     string
+		args     []string
+		want     []string
+		wantCode int
+	}{
+		{
+			name:     "clean",
+			args:     []string{filepath.Join(dir, "clean.yaml")},
+			wantCode: exitOK,
+		},
+		{
+			name:     "findings",
+			args:     []string{filepath.Join(dir, "clean.yaml"), filepath.Join(dir, "loop.yaml")},
+			want:     []string{filepath.Join(dir, "loop.yaml") + ": policy, rule 1: mutation-loop: rewrites nginx to mirror/nginx, which rule 1 rewrites again to mirror/mirror/nginx"},
+			wantCode: exitFailure,
+		},
+		{
+			name:     "json",
+			args:     []string{"--output", "json", filepath.Join(dir, "loop.yaml")},
+			want:     []string{`"check": "mutation-loop"`},
+			wantCode: exitFailure,
+		},
+		{
+			name:     "invalid policy",
+			args:     []string{filepath.Join(dir, "invalid.yaml")},
+			wantCode: exitError,
+		},
+		{
+			name:     "no policy file",
+			wantCode: exitError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := runLint(tt.args, &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Errorf("runLint() = %v, want %v, stdout: %s, stderr: %s", code, tt.wantCode, stdout.String(), stderr.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("output does not contain %q:\n%s", want, stdout.String())
+				}
+			}
+		})
+	}
+}
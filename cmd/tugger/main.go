@@ -12,7 +12,6 @@
 package main
 
 import (
-	"bytes"
 	"crypto/tls"
 	"encoding/json"
 	"flag"
@@ -24,25 +23,23 @@ import (
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/name"
-	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/infobloxopen/atlas-app-toolkit/logging"
 	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/sirupsen/logrus"
-	"k8s.io/api/admission/v1beta1"
+	admissionv1 "k8s.io/api/admission/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 var (
-	ifExists       bool
-	log            *logrus.Logger
-	policy         *Policy
-	listenPort     int
-	tlsCertFile    string
-	tlsKeyFile     string
-	slackDupeCache *cache.Cache
-	slackDedupeTTL time.Duration
+	ifExists    bool
+	log         *logrus.Logger
+	policy      *Policy
+	reloader    *policyReloader
+	listenPort  int
+	tlsCertFile string
+	tlsKeyFile  string
 )
 
 var (
@@ -52,8 +49,8 @@ var (
 	whitelistRegistries   = os.Getenv("WHITELIST_REGISTRIES")
 	whitelistNamespaces   = os.Getenv("WHITELIST_NAMESPACES")
 	webhookUrl            = os.Getenv("WEBHOOK_URL")
-	whitelistedNamespaces = strings.Split(whitelistNamespaces, ",")
-	whitelistedRegistries = strings.Split(whitelistRegistries, ",")
+	whitelistedNamespaces = namespaceWhitelist(strings.Split(whitelistNamespaces, ","))
+	whitelistedRegistries = registryWhitelist(strings.Split(whitelistRegistries, ","))
 )
 
 type patch struct {
@@ -62,36 +59,107 @@ type patch struct {
 	Value interface{} `json:"value,omitempty"`
 }
 
-type SlackRequestBody struct {
-	Text string `json:"text"`
-}
-
 func main() {
+	// Subcommands run offline against manifests instead of serving webhook requests
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "check":
+			os.Exit(runCheck(os.Args[2:], os.Stdin, os.Stdout, os.Stderr))
+		case "test":
+			os.Exit(runPolicyTest(os.Args[2:], os.Stdout, os.Stderr))
+		case "explain":
+			os.Exit(runExplain(os.Args[2:], os.Stdout, os.Stderr))
+		case "lint":
+			os.Exit(runLint(os.Args[2:], os.Stdout, os.Stderr))
+		}
+	}
+
 	flag.BoolVar(&ifExists, "if-exists", false, "makes the mutation conditional on whether the mutated image name exists in the registry")
 	logLevel := flag.String("log-level", "info", "log verbosity")
 	policyFile := flag.String("policy-file", "", "YAML file defining allowed image name patterns (see readme)")
 	flag.IntVar(&listenPort, "port", 443, "HTTPS Port to listen on for webhook requests.")
 	flag.StringVar(&tlsCertFile, "tls-cert", "/etc/admission-controller/tls/tls.crt", "TLS certificate file.")
 	flag.StringVar(&tlsKeyFile, "tls-key", "/etc/admission-controller/tls/tls.key", "TLS key file.")
-	flag.DurationVar(&slackDedupeTTL, "slack-dedupe-ttl", 3*time.Minute, "drops repeat Slack notifications until this amount of time elapses (requires WEBHOOK_URL defined)")
+	flag.DurationVar(&registryTimeout, "registry-timeout", registryTimeout, "deadline for a single registry lookup, 0 disables it")
+	flag.StringVar(&registryUnreachable, "registry-unreachable", registryUnreachable, "what an unreachable registry means for the Exists condition: missing, exists or deny")
+	existsCacheTTL := flag.Duration("exists-cache-ttl", 5*time.Minute, "caches that an image exists for the Exists condition for this amount of time, 0 disables the cache")
+	existsCacheNegativeTTL := flag.Duration("exists-cache-negative-ttl", 30*time.Second, "caches that an image does not exist for the Exists condition for this amount of time")
+	existsCacheSize := flag.Int("exists-cache-size", 1000, "maximum number of images in the Exists condition cache")
+	decisionLog := flag.String("decision-log", "", "writes a JSON decision record per admission to stdout, or to this file rotated by size")
+	decisionLogMaxSize := flag.Int("decision-log-max-size", 100, "rotates the decision log file after this many megabytes")
+	decisionLogMaxBackups := flag.Int("decision-log-max-backups", 5, "number of rotated decision log files to keep, 0 keeps all")
+	decisionLogMaxAge := flag.Int("decision-log-max-age", 0, "days to keep rotated decision log files, 0 keeps them regardless of age")
+	kubeEvents := flag.Bool("events", false, "records Kubernetes Events for denied, missing and rewritten images against the owning workload or namespace")
+	eventsQPS := flag.Float64("events-qps", 1.0/300, "events per second allowed per object after the burst, like the kubelet's event correlator")
+	eventsBurst := flag.Int("events-burst", 25, "events recorded per object before --events-qps applies")
+	flag.IntVar(&containerConcurrency, "container-concurrency", containerConcurrency, "maximum number of containers of one admission request evaluated in parallel")
+	flag.BoolVar(&podPullSecrets, "pod-pull-secrets", podPullSecrets, "authenticates registry lookups with the pod's imagePullSecrets, REGISTRY_SECRET_NAME and the service account's pull secrets")
+	flag.BoolVar(&pinDigests, "pin-digests", false, "makes the mutation pin every allowed image to the digest its tag resolves to, e.g. nginx:1.19@sha256:...")
+	flag.BoolVar(&legacyWhitelistMatching, "legacy-whitelist-matching", false, "match WHITELIST_NAMESPACES and WHITELIST_REGISTRIES entries as substrings, as before exact and anchored matching was introduced")
+	notifierKind := flag.String("notifier", notifierSlack, "where notifications are posted with WEBHOOK_URL: slack, teams, webhook or cloudevents")
+	notifierTemplate := flag.String("notifier-template", "", "Go template of the JSON body of webhook notifications, with the fields .Message, .Text, .Env and .Time")
+	cloudEventsSource := flag.String("cloudevents-source", "tugger", "source attribute of CloudEvents notifications")
+	notificationDedupeTTL := flag.Duration("notification-dedupe-ttl", 3*time.Minute, "drops repeat notifications until this amount of time elapses (requires WEBHOOK_URL defined)")
+	debugEndpoints := flag.Bool("debug-endpoints", false, "serves GET /explain?image=...&namespace=..., which traces how the policy evaluates an image")
+	flag.DurationVar(notificationDedupeTTL, "slack-dedupe-ttl", 3*time.Minute, "deprecated: use --notification-dedupe-ttl")
 	flag.Parse()
 
 	log = logging.New(*logLevel)
+	registries := whitelistedRegistries
+	if *policyFile != "" {
+		// the policy file replaces WHITELIST_REGISTRIES
+		registries = nil
+	}
+	warnAmbiguousWhitelists(whitelistedNamespaces, registries)
+	if err := validateRegistryUnreachable(registryUnreachable); err != nil {
+		log.WithError(err).Fatal("invalid --registry-unreachable")
+	}
 
 	if *policyFile != "" {
 		var err error
 		if policy, err = NewPolicy(WithConfigFile(*policyFile)); err != nil {
 			log.WithError(err).WithField("policy-file", *policyFile).Fatal("failed to load policy file")
 		}
+		if policy.usesNamespaceSelector() {
+			startNamespaceInformer()
+			waitForNamespaceSync(namespaceSyncTimeout)
+		}
+		reloader = newPolicyReloader(*policyFile)
+		if err := reloader.watch(make(chan struct{})); err != nil {
+			log.WithError(err).WithField("policy-file", *policyFile).Fatal("failed to watch policy file")
+		}
+	}
+
+	if *kubeEvents {
+		startEventRecorder(float32(*eventsQPS), *eventsBurst)
 	}
 
-	if webhookUrl != "" && slackDedupeTTL > 0 {
-		slackDupeCache = cache.New(slackDedupeTTL, 10*time.Minute)
+	if *decisionLog != "" {
+		decisions = newDecisionLogger(*decisionLog, *decisionLogMaxSize, *decisionLogMaxBackups, *decisionLogMaxAge)
+	}
+
+	if *existsCacheTTL > 0 {
+		existsCache = newImageCache(*existsCacheTTL, *existsCacheNegativeTTL, *existsCacheSize)
+	}
+
+	if webhookUrl != "" {
+		var err error
+		if notifier, err = newNotifier(*notifierKind, webhookUrl, *notifierTemplate, *cloudEventsSource); err != nil {
+			log.WithError(err).Fatal("invalid --notifier")
+		}
+		if *notificationDedupeTTL > 0 {
+			notificationDupeCache = cache.New(*notificationDedupeTTL, 10*time.Minute)
+		}
 	}
 
 	http.HandleFunc("/ping", healthCheck)
+	http.HandleFunc("/status", statusHandler)
+	http.Handle("/metrics", promhttp.Handler())
 	http.HandleFunc("/mutate", mutateAdmissionReviewHandler)
 	http.HandleFunc("/validate", validateAdmissionReviewHandler)
+	if *debugEndpoints {
+		http.HandleFunc("/explain", explainHandler)
+	}
 	s := http.Server{
 		Addr: fmt.Sprintf(":%d", listenPort),
 		TLSConfig: &tls.Config{
@@ -116,93 +184,171 @@ func mutateAdmissionReviewHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Debugf(string(data))
 
-	ar := v1beta1.AdmissionReview{}
-	if err := json.Unmarshal(data, &ar); err != nil || ar.Request == nil {
+	req, version, err := decodeAdmissionReview(data)
+	if err != nil {
 		log.WithError(err).WithField("body", string(data)).Error("could not parse request")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	namespace := ar.Request.Namespace
+	resp, record, admitErr := mutateRequest(req)
+	if admitErr != nil {
+		w.WriteHeader(admitErr.status)
+		return
+	}
+
+	admissionRequests.WithLabelValues("mutate", req.Namespace, admissionOutcome(resp)).Inc()
+	decisions.Log(record, resp)
+	writeAdmissionResponse(w, version, req, resp)
+}
+
+// mutateRequest rewrites the images of the pod template in an admission request and returns the
+// response patching them, along with the decision record of the request. The request is denied
+// when an image can not be verified because its registry is unreachable with
+// --registry-unreachable=deny.
+func mutateRequest(req *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, *decisionRecord, *admissionError) {
+	namespace := req.Namespace
 	log.Debugf("AdmissionReview Namespace is: %s", namespace)
 
-	admissionResponse := v1beta1.AdmissionResponse{Allowed: false}
+	admissionResponse := admissionv1.AdmissionResponse{Allowed: false}
 	patches := []patch{}
+	snapshot := currentPolicy()
+	policy := snapshot
+	if policy != nil {
+		policy = policy.ForNamespace(namespace)
+	}
+	record := newDecisionRecord("mutate", req)
 
-	pod := v1.Pod{}
-	if !contains(whitelistedNamespaces, namespace) {
-		if err := json.Unmarshal(ar.Request.Object.Raw, &pod); err != nil {
-			log.WithError(err).WithField("object", ar.Request.Object.Raw).Error("could unmarshal pod spec")
-			w.WriteHeader(http.StatusBadRequest)
-			return
+	var tpl *podTemplate
+	if !whitelistedNamespaces.Match(namespace) {
+		var err error
+		if tpl, err = newPodTemplate(req); err != nil {
+			log.WithError(err).WithField("object", req.Object.Raw).Error("could unmarshal pod spec")
+			return nil, nil, &admissionError{status: http.StatusBadRequest, err: err}
+		}
+		if tpl == nil {
+			log.Printf("Kind %s is not handled", req.Kind.Kind)
+			record.Reason = "kind is not handled"
+		} else if tpl.admittedThrough != "" {
+			log.Printf("%s is admitted through its %s", req.Kind.Kind, tpl.admittedThrough)
+			record.Reason = "admitted through its " + tpl.admittedThrough
+			tpl = nil
+		} else {
+			record.setRuleSet(snapshot, policy)
 		}
+	} else {
+		log.Printf("Namespace is %s Whitelisted", namespace)
+		record.Reason = "namespace is whitelisted"
+	}
 
-		// Handle Containers
-		for i, container := range pod.Spec.Containers {
-			originalImage := container.Image
-			if handleContainer(&container, dockerRegistryUrl) {
-				patches = append(
-					patches, patch{
-						Op:    "replace",
-						Path:  fmt.Sprintf("/spec/containers/%d/image", i),
-						Value: container.Image,
-					},
-					patch{
-						Op:    "add",
-						Path:  fmt.Sprintf("/metadata/annotations/tugger-original-image-%d", i),
-						Value: originalImage,
-					},
-				)
-			}
+	// Registry lookups authenticate with the pod's pull secrets
+	var keychain authn.Keychain
+	if tpl != nil {
+		keychain = podKeychain(namespace, tpl.spec)
+		if policy != nil && keychain != nil {
+			policy = policy.WithKeychain(keychain)
 		}
+	}
 
-		// Handle init containers
-		for i, container := range pod.Spec.InitContainers {
-			originalImage := container.Image
-			if handleContainer(&container, dockerRegistryUrl) {
-				patches = append(patches,
-					patch{
-						Op:    "replace",
-						Path:  fmt.Sprintf("/spec/initContainers/%d/image", i),
-						Value: container.Image,
-					},
-					patch{
-						Op:    "add",
-						Path:  fmt.Sprintf("/metadata/annotations/tugger-original-init-image-%d", i),
-						Value: originalImage,
-					},
-				)
+	mode := modeEnforce
+	if policy != nil {
+		mode = policy.EnforcementMode(namespace)
+	}
+	causes := []metav1.StatusCause{}
+	if tpl != nil {
+		// Containers are evaluated in parallel, and patched in pod spec order
+		refs := tpl.containers()
+		results := make([]decision, len(refs))
+		originalImages := make([]string, len(refs))
+		forEachParallel(len(refs), containerConcurrency, func(i int) {
+			originalImages[i] = refs[i].Image
+			results[i] = evaluateContainer(policy, &refs[i].Container, dockerRegistryUrl, keychain)
+		})
+		for i, ref := range refs {
+			record.addContainer(ref, originalImages[i], results[i])
+			// The image is left as it is, validation skips rewrite rules and would not say why
+			if results[i].unreachable {
+				message := fmt.Sprintf("Image is denied: %s: %s", originalImages[i], results[i].reason)
+				cause := metav1.StatusCause{
+					Type:    metav1.CauseTypeFieldValueInvalid,
+					Field:   tpl.imageField(ref),
+					Message: fmt.Sprintf("%s %s: %s (rules: %s)", containerTypes[ref.field], ref.Name, message, record.RuleSet),
+				}
+				switch mode {
+				case modeWarn:
+					admissionResponse.Warnings = append(admissionResponse.Warnings, cause.Message)
+				case modeAudit:
+					addAuditDenial(&admissionResponse, cause.Message)
+				default:
+					causes = append(causes, cause)
+					recordEvent(req, v1.EventTypeWarning, eventImageDenied, "%s", cause.Message)
+				}
+			}
+			if results[i].missing {
+				recordEvent(req, v1.EventTypeWarning, eventImageNotFound, "%s %s: %s, the image was not rewritten",
+					containerTypes[ref.field], ref.Name, results[i].reason)
+			}
+			if ref.Image == originalImages[i] {
+				continue
+			}
+			recordEvent(req, v1.EventTypeNormal, eventImageRewritten, "%s %s: rewrote image %s to %s",
+				containerTypes[ref.field], ref.Name, originalImages[i], ref.Image)
+			patches = append(patches, patch{
+				Op:    "replace",
+				Path:  fmt.Sprintf("%s/spec/%s/%d/image", tpl.path, ref.field, ref.index),
+				Value: ref.Image,
+			})
+			if !tpl.ephemeralOnly {
+				patches = append(patches, patch{
+					Op:    "add",
+					Path:  fmt.Sprintf("%s/metadata/annotations/%s-%d", tpl.path, originalImageAnnotations[ref.field], ref.index),
+					Value: originalImages[i],
+				})
 			}
 		}
-	} else {
-		log.Printf("Namespace is %s Whitelisted", namespace)
+	}
+
+	if len(causes) > 0 {
+		admissionResponse.Result = getInvalidContainerResponse(causes)
+		return &admissionResponse, record, nil
 	}
 
 	admissionResponse.Allowed = true
-	if len(patches) > 0 {
+	// The API server ignores metadata and pod spec changes other than the ephemeral containers
+	// through the pods/ephemeralcontainers subresource, so only their images are patched
+	if len(patches) > 0 && !tpl.ephemeralOnly {
 
 		// If the pod doesnt have annotations prepend a patch
 		// so the annotations map exists before the patches above
-		if pod.ObjectMeta.Annotations == nil {
+		if tpl.meta.Annotations == nil {
+			patches = append([]patch{patch{
+				Op:    "add",
+				Path:  tpl.path + "/metadata/annotations",
+				Value: map[string]string{},
+			}}, patches...)
+		}
+
+		// A workload's pod template may omit metadata altogether
+		if tpl.missingMetadata() {
 			patches = append([]patch{patch{
 				Op:    "add",
-				Path:  "/metadata/annotations",
+				Path:  tpl.path + "/metadata",
 				Value: map[string]string{},
 			}}, patches...)
 		}
 
 		// If the pod doesn't have labels append a patch
-		if pod.ObjectMeta.Labels == nil {
+		if tpl.meta.Labels == nil {
 			patches = append(patches, patch{
 				Op:    "add",
-				Path:  "/metadata/labels",
+				Path:  tpl.path + "/metadata/labels",
 				Value: map[string]string{},
 			})
 		}
 
 		// Inject image pull secret
 		if registrySecretName != "" {
-			imagePullSecrets := pod.Spec.ImagePullSecrets
+			imagePullSecrets := tpl.spec.ImagePullSecrets
 			if imagePullSecrets == nil {
 				imagePullSecrets = []v1.LocalObjectReference{}
 			}
@@ -213,7 +359,7 @@ func mutateAdmissionReviewHandler(w http.ResponseWriter, r *http.Request) {
 			)
 			patches = append(patches, patch{
 				Op:    "add",
-				Path:  "/spec/imagePullSecrets",
+				Path:  tpl.path + "/spec/imagePullSecrets",
 				Value: imagePullSecrets,
 			})
 		}
@@ -221,86 +367,86 @@ func mutateAdmissionReviewHandler(w http.ResponseWriter, r *http.Request) {
 		// Add label
 		patches = append(patches, patch{
 			Op:    "add",
-			Path:  "/metadata/labels/tugger-modified",
+			Path:  tpl.path + "/metadata/labels/tugger-modified",
 			Value: "true",
 		})
+	}
 
+	if len(patches) > 0 {
 		patchContent, err := json.Marshal(patches)
 		if err != nil {
 			log.WithError(err).WithField("patches", patches).Error("could not marshal patches")
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			return nil, nil, &admissionError{status: http.StatusInternalServerError, err: err}
 		}
 
 		admissionResponse.Patch = patchContent
-		pt := v1beta1.PatchTypeJSONPatch
+		pt := admissionv1.PatchTypeJSONPatch
 		admissionResponse.PatchType = &pt
 	}
+	return &admissionResponse, record, nil
+}
 
-	ar = v1beta1.AdmissionReview{
-		Response: &admissionResponse,
-	}
-
-	data, err = json.Marshal(ar)
-	if err != nil {
-		log.WithError(err).WithField("resp", ar).Error("could not marshal response")
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write(data)
+// handleContainer mutates the container's image and reports whether it was changed. Registry
+// lookups authenticate with the keychain if it isn't nil.
+func handleContainer(policy *Policy, container *v1.Container, dockerRegistryUrl string, keychain authn.Keychain) bool {
+	originalImage := container.Image
+	evaluateContainer(policy, container, dockerRegistryUrl, keychain)
+	return originalImage != container.Image
 }
 
-// imageExists verifies an image exists in the remote registry
-func imageExists(image string) bool {
-	ref, err := name.ParseReference(image)
-	if err != nil {
-		log.WithError(err).WithField("image", image).Error("could not parse image")
-		return false
-	}
+// evaluateContainer mutates the container's image and returns the decision for it
+func evaluateContainer(policy *Policy, container *v1.Container, dockerRegistryUrl string, keychain authn.Keychain) decision {
+	log.Println("Container Image is", container.Image)
 
-	if _, err := remote.Image(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain)); err != nil {
-		log.WithError(err).WithField("image", image).Error("could not fetch image")
-		return false
+	d := mutateContainer(policy, container, dockerRegistryUrl, keychain)
+	if d.allowed && pinDigests {
+		pinContainerDigest(container, keychain)
 	}
-
-	return true
+	d.image = container.Image
+	return d
 }
 
-func handleContainer(container *v1.Container, dockerRegistryUrl string) bool {
-	log.Println("Container Image is", container.Image)
-
+// mutateContainer rewrites the container's image and decides whether the image is allowed
+func mutateContainer(policy *Policy, container *v1.Container, dockerRegistryUrl string, keychain authn.Keychain) decision {
 	if policy != nil {
 		originalImage := container.Image
-		container.Image, _ = policy.MutateImage(container.Image)
+		d := policy.mutate(container.Image)
+		container.Image = d.image
 		if originalImage != container.Image {
 			log.Println("Changing image from", originalImage, "to", container.Image)
-			return true
 		}
-		return false
+		return d
 	}
 
 	// backwards compatibility when policy is undefined
-	if containsRegisty(whitelistedRegistries, container.Image) {
+	if whitelistedRegistries.Match(container.Image) {
 		log.Printf("Image is being pulled from Private Registry: %s", container.Image)
-		return false
+		return decision{allowed: true, reason: "registry is whitelisted"}
 	}
 	message := fmt.Sprintf("Image is not being pulled from Private Registry: %s", container.Image)
 	log.Printf(message)
 
 	newImage := dockerRegistryUrl + "/" + container.Image
-	if ifExists && !imageExists(newImage) {
-		message := fmt.Sprintf("%s does not exist in private registry, skipping patching of %s", newImage, container.Name)
-		log.Print(message)
-		SendSlackNotification(message)
-		return false
+	if ifExists {
+		exists, err := imageExists(newImage, keychain)
+		if err != nil {
+			message := fmt.Sprintf("%s, skipping patching of %s", err, container.Name)
+			log.Print(message)
+			sendNotification(message)
+			return decision{reason: err.Error(), unreachable: true}
+		}
+		if !exists {
+			message := fmt.Sprintf("%s does not exist in private registry, skipping patching of %s", newImage, container.Name)
+			log.Print(message)
+			sendNotification(message)
+			return decision{reason: fmt.Sprintf("%s does not exist in private registry", newImage), missing: true}
+		}
 	}
 
 	log.Println("Changing image from", container.Image, "to", newImage)
 
 	container.Image = newImage
-	return true
+	return decision{allowed: true, reason: "rewritten to DOCKER_REGISTRY_URL"}
 }
 
 func validateAdmissionReviewHandler(w http.ResponseWriter, r *http.Request) {
@@ -317,103 +463,148 @@ func validateAdmissionReviewHandler(w http.ResponseWriter, r *http.Request) {
 
 	log.Debugf(string(data))
 
-	ar := v1beta1.AdmissionReview{}
-	if err := json.Unmarshal(data, &ar); err != nil {
+	req, version, err := decodeAdmissionReview(data)
+	if err != nil {
 		log.WithError(err).WithField("body", string(data)).Error("could not parse request")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	namespace := ar.Request.Namespace
+	resp, record, admitErr := validateRequest(req)
+	if admitErr != nil {
+		w.WriteHeader(admitErr.status)
+		return
+	}
+
+	admissionRequests.WithLabelValues("validate", req.Namespace, admissionOutcome(resp)).Inc()
+	decisions.Log(record, resp)
+	writeAdmissionResponse(w, version, req, resp)
+}
+
+// validateRequest checks the images of the pod template in an admission request and returns the
+// response admitting or denying it, along with the decision record of the request
+func validateRequest(req *admissionv1.AdmissionRequest) (*admissionv1.AdmissionResponse, *decisionRecord, *admissionError) {
+	namespace := req.Namespace
 	log.Debugf("AdmissionReview Namespace is: %s", namespace)
 
-	admissionResponse := v1beta1.AdmissionResponse{Allowed: true}
-	if !contains(whitelistedNamespaces, namespace) {
-		pod := v1.Pod{}
-		if err := json.Unmarshal(ar.Request.Object.Raw, &pod); err != nil {
-			log.WithError(err).WithField("object", ar.Request.Object.Raw).Error("could unmarshal pod spec")
-			w.WriteHeader(http.StatusBadRequest)
-			return
+	admissionResponse := admissionv1.AdmissionResponse{Allowed: true}
+	record := newDecisionRecord("validate", req)
+	if !whitelistedNamespaces.Match(namespace) {
+		tpl, err := newPodTemplate(req)
+		if err != nil {
+			log.WithError(err).WithField("object", req.Object.Raw).Error("could unmarshal pod spec")
+			return nil, nil, &admissionError{status: http.StatusBadRequest, err: err}
+		}
+		if tpl == nil {
+			log.Printf("Kind %s is not handled", req.Kind.Kind)
+			record.Reason = "kind is not handled"
+			return &admissionResponse, record, nil
+		}
+		if tpl.admittedThrough != "" {
+			log.Printf("%s is admitted through its %s", req.Kind.Kind, tpl.admittedThrough)
+			record.Reason = "admitted through its " + tpl.admittedThrough
+			return &admissionResponse, record, nil
 		}
 
-		var validateImage func(string) bool
+		mode := modeEnforce
+		ruleSet := "WHITELIST_REGISTRIES"
+		var validateImage func(string) decision
+		snapshot := currentPolicy()
+		policy := snapshot
 		if policy != nil {
-			validateImage = policy.ValidateImage
+			policy = policy.ForNamespace(namespace)
+			if keychain := podKeychain(namespace, tpl.spec); keychain != nil {
+				policy = policy.WithKeychain(keychain)
+			}
+			mode = policy.EnforcementMode(namespace)
+			ruleSet = policy.RuleSet()
+			validateImage = policy.validate
 		} else {
 			// backwards compatibility when policy is undefined
-			validateImage = func(image string) bool {
-				return containsRegisty(whitelistedRegistries, image)
+			validateImage = func(image string) decision {
+				if whitelistedRegistries.Match(image) {
+					return decision{image: image, allowed: true, reason: "registry is whitelisted"}
+				}
+				return decision{image: image, reason: "registry is not whitelisted"}
 			}
 		}
-
-		// Handle containers
-		containers := []v1.Container{}
-		containers = append(containers, pod.Spec.Containers...)
-		containers = append(containers, pod.Spec.InitContainers...)
-		for _, container := range containers {
-			log.Println("Container Image is", container.Image)
-			if !validateImage(container.Image) {
-				message := fmt.Sprintf("Image is not being pulled from Private Registry: %s", container.Image)
-				log.Printf(message)
-				SendSlackNotification(message)
-				admissionResponse.Allowed = false
-				admissionResponse.Result = getInvalidContainerResponse(message)
-				goto done
-			} else {
+		record.setRuleSet(snapshot, policy)
+		record.Mode = mode
+
+		// Handle containers in parallel, reporting every image that is not allowed in pod spec order
+		refs := tpl.containers()
+		results := make([]decision, len(refs))
+		forEachParallel(len(refs), containerConcurrency, func(i int) {
+			log.Println("Container Image is", refs[i].Image)
+			results[i] = validateImage(refs[i].Image)
+		})
+		causes := []metav1.StatusCause{}
+		for i, container := range refs {
+			record.addContainer(container, container.Image, results[i])
+			if results[i].allowed {
 				log.Printf("Image is being pulled from Private Registry: %s", container.Image)
-				admissionResponse.Allowed = true && admissionResponse.Allowed
+				continue
+			}
+
+			message := fmt.Sprintf("Image is not being pulled from Private Registry: %s", container.Image)
+			if results[i].rule != 0 {
+				message = fmt.Sprintf("Image is denied: %s: %s", container.Image, results[i].reason)
+			}
+			log.WithField("mode", mode).Print(message)
+
+			cause := metav1.StatusCause{
+				Type:    metav1.CauseTypeFieldValueInvalid,
+				Field:   tpl.imageField(container),
+				Message: fmt.Sprintf("%s %s: %s (rules: %s)", containerTypes[container.field], container.Name, message, ruleSet),
+			}
+			switch mode {
+			case modeWarn:
+				admissionResponse.Warnings = append(admissionResponse.Warnings, cause.Message)
+				recordEvent(req, v1.EventTypeWarning, eventImageWouldBeDenied, "%s (mode: %s)", cause.Message, mode)
+			case modeAudit:
+				addAuditDenial(&admissionResponse, cause.Message)
+				recordEvent(req, v1.EventTypeWarning, eventImageWouldBeDenied, "%s (mode: %s)", cause.Message, mode)
+			default:
+				// only denials are notified, images allowed in warn and audit mode would flood the channel
+				sendNotification(message)
+				causes = append(causes, cause)
+				recordEvent(req, v1.EventTypeWarning, eventImageDenied, "%s", cause.Message)
 			}
 		}
+		if len(causes) > 0 {
+			admissionResponse.Allowed = false
+			admissionResponse.Result = getInvalidContainerResponse(causes)
+		}
 	} else {
 		log.Printf("Namespace is %s Whitelisted", namespace)
-		admissionResponse.Allowed = true && admissionResponse.Allowed
-	}
-
-done:
-	ar = v1beta1.AdmissionReview{
-		Response: &admissionResponse,
+		record.Reason = "namespace is whitelisted"
 	}
-
-	data, err = json.Marshal(ar)
-	if err != nil {
-		log.Println(err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-
-	w.WriteHeader(http.StatusOK)
-	w.Write(data)
+	return &admissionResponse, record, nil
 }
 
-func getInvalidContainerResponse(message string) *metav1.Status {
+func getInvalidContainerResponse(causes []metav1.StatusCause) *metav1.Status {
+	messages := []string{}
+	for _, cause := range causes {
+		messages = append(messages, cause.Message)
+	}
 	return &metav1.Status{
-		Reason: metav1.StatusReasonInvalid,
+		Message: strings.Join(messages, "; "),
+		Reason:  metav1.StatusReasonInvalid,
 		Details: &metav1.StatusDetails{
-			Causes: []metav1.StatusCause{
-				{Message: message},
-			},
+			Causes: causes,
 		},
 	}
 }
 
-// if current namespace is part of whitelisted namespaces
-func contains(arr []string, str string) bool {
-	for _, a := range arr {
-		if a == str || strings.Contains(a, str) {
-			return true
-		}
+// addAuditDenial records a denial that was not enforced in the response's audit annotations
+func addAuditDenial(resp *admissionv1.AdmissionResponse, message string) {
+	if resp.AuditAnnotations == nil {
+		resp.AuditAnnotations = map[string]string{}
 	}
-	return false
-}
-
-// if current registry is part of whitelisted registries
-func containsRegisty(arr []string, str string) bool {
-	for _, a := range arr {
-		if a == str || strings.Contains(str, a) {
-			return true
-		}
+	if denied, ok := resp.AuditAnnotations["would-deny"]; ok {
+		message = denied + "; " + message
 	}
-	return false
+	resp.AuditAnnotations["would-deny"] = message
 }
 
 // ping responds to the request with a plain-text "Ok" message.
@@ -421,49 +612,3 @@ func healthCheck(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("Serving request: %s", r.URL.Path)
 	fmt.Fprintf(w, "Ok")
 }
-
-// SendSlackNotification will post to an 'Incoming Webook' url setup in Slack Apps. It accepts
-// some text and the slack channel is saved within Slack.
-func SendSlackNotification(msg string) {
-	if webhookUrl == "" {
-		log.Debugln("Slack Webhook URL is not provided")
-		return
-	}
-
-	if env != "" {
-		msg = fmt.Sprintf("[%s] %s", env, msg)
-	}
-
-	if slackDupeCache != nil {
-		if err := slackDupeCache.Add(msg, struct{}{}, cache.DefaultExpiration); err != nil {
-			log.Info("suppressing duplicate Slack message")
-			return
-		}
-	}
-
-	slackBody, _ := json.Marshal(SlackRequestBody{Text: msg})
-	req, err := http.NewRequest(http.MethodPost, webhookUrl, bytes.NewBuffer(slackBody))
-	if err != nil {
-		log.WithError(err).Error("unable to build slack request")
-		return
-	}
-
-	req.Header.Add("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.WithError(err).Error("got error from Slack")
-		if slackDupeCache != nil {
-			slackDupeCache.Delete(msg)
-		}
-		return
-	}
-	defer resp.Body.Close()
-
-	buf := new(bytes.Buffer)
-	buf.ReadFrom(resp.Body)
-	if buf.String() != "ok" {
-		log.WithField("resp", buf.String()).Errorln("Non-ok response returned from Slack")
-	}
-}
@@ -16,20 +16,21 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
-	"time"
 
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/infobloxopen/atlas-app-toolkit/logging"
 	"github.com/jarcoal/httpmock"
-	"github.com/patrickmn/go-cache"
 )
 
 const (
 	mockSlackURL            = "https://slack/"
+	ruleSetPlaceholder      = "<rules>"
 	trustedRegistry         = "private-registry.cluster.local"
 	trustedAdmissionRequest = `
 	{
 		"kind": "AdmissionReview",
 		"request": {
+		  "uid": "11111111-6393-11e8-b7cc-42010a800002",
 		  "kind": {
 			"kind": "Pod",
 			"version": "v1"
@@ -70,6 +71,7 @@ const (
 	{
 		"kind": "AdmissionReview",
 		"request": {
+		  "uid": "22222222-6393-11e8-b7cc-42010a800002",
 		  "kind": {
 			"kind": "Pod",
 			"version": "v1"
@@ -110,6 +112,7 @@ const (
 	  {
 		  "kind": "AdmissionReview",
 		  "request": {
+			"uid": "33333333-6393-11e8-b7cc-42010a800002",
 			"kind": {
 			  "kind": "Pod",
 			  "version": "v1"
@@ -146,10 +149,231 @@ const (
 			}
 		  }
 		}`
+	untrustedAdmissionRequestV1 = `
+	{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind": "AdmissionReview",
+		"request": {
+		  "uid": "705ab4f5-6393-11e8-b7cc-42010a800002",
+		  "kind": {
+			"kind": "Pod",
+			"version": "v1"
+		  },
+		  "name": "myapp",
+		  "namespace": "foobar",
+		  "object": {
+			"metadata": {
+			  "name": "myapp",
+			  "namespace": "foobar"
+			},
+			"spec": {
+			  "containers": [
+				{
+				  "image": "nginx",
+				  "name": "nginx-frontend"
+				}
+			  ]
+			}
+		  }
+		}
+	  }`
+	untrustedDeploymentAdmissionRequest = `
+	{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind": "AdmissionReview",
+		"request": {
+		  "uid": "55555555-6393-11e8-b7cc-42010a800002",
+		  "kind": {
+			"group": "apps",
+			"kind": "Deployment",
+			"version": "v1"
+		  },
+		  "name": "myapp",
+		  "namespace": "foobar",
+		  "object": {
+			"metadata": {
+			  "name": "myapp",
+			  "namespace": "foobar"
+			},
+			"spec": {
+			  "template": {
+				"metadata": {
+				  "labels": {
+					"app": "myapp"
+				  }
+				},
+				"spec": {
+				  "containers": [
+					{
+					  "image": "nginx",
+					  "name": "nginx-frontend"
+					}
+				  ]
+				}
+			  }
+			}
+		  }
+		}
+	  }`
+	untrustedOwnedReplicaSetAdmissionRequest = `
+	{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind": "AdmissionReview",
+		"request": {
+		  "uid": "88888888-6393-11e8-b7cc-42010a800002",
+		  "kind": {
+			"group": "apps",
+			"kind": "ReplicaSet",
+			"version": "v1"
+		  },
+		  "namespace": "foobar",
+		  "object": {
+			"metadata": {
+			  "generateName": "myapp-5d8f7c9b4-",
+			  "namespace": "foobar",
+			  "ownerReferences": [
+				{
+				  "apiVersion": "apps/v1",
+				  "kind": "Deployment",
+				  "name": "myapp",
+				  "uid": "55555555-6393-11e8-b7cc-42010a800002",
+				  "controller": true
+				}
+			  ]
+			},
+			"spec": {
+			  "template": {
+				"metadata": {
+				  "labels": {
+					"app": "myapp"
+				  }
+				},
+				"spec": {
+				  "containers": [
+					{
+					  "image": "nginx",
+					  "name": "nginx-frontend"
+					}
+				  ]
+				}
+			  }
+			}
+		  }
+		}
+	  }`
+	untrustedCronJobAdmissionRequest = `
+	{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind": "AdmissionReview",
+		"request": {
+		  "uid": "66666666-6393-11e8-b7cc-42010a800002",
+		  "kind": {
+			"group": "batch",
+			"kind": "CronJob",
+			"version": "v1"
+		  },
+		  "name": "myjob",
+		  "namespace": "foobar",
+		  "object": {
+			"metadata": {
+			  "name": "myjob",
+			  "namespace": "foobar"
+			},
+			"spec": {
+			  "schedule": "* * * * *",
+			  "jobTemplate": {
+				"spec": {
+				  "template": {
+					"spec": {
+					  "containers": [
+						{
+						  "image": "` + trustedRegistry + `/busybox",
+						  "name": "busybox"
+						},
+						{
+						  "image": "mysql",
+						  "name": "mysql-backend"
+						}
+					  ]
+					}
+				  }
+				}
+			  }
+			}
+		  }
+		}
+	  }`
+	untrustedEphemeralAdmissionRequest = `
+	{
+		"apiVersion": "admission.k8s.io/v1",
+		"kind": "AdmissionReview",
+		"request": {
+		  "uid": "77777777-6393-11e8-b7cc-42010a800002",
+		  "kind": {
+			"kind": "Pod",
+			"version": "v1"
+		  },
+		  "subResource": "ephemeralcontainers",
+		  "operation": "UPDATE",
+		  "name": "myapp",
+		  "namespace": "foobar",
+		  "object": {
+			"metadata": {
+			  "name": "myapp",
+			  "namespace": "foobar",
+			  "annotations": {
+				"tugger-original-image-0": "nginx"
+			  },
+			  "labels": {
+				"tugger-modified": "true"
+			  }
+			},
+			"spec": {
+			  "containers": [
+				{
+				  "image": "nginx",
+				  "name": "nginx-frontend"
+				}
+			  ],
+			  "ephemeralContainers": [
+				{
+				  "image": "alpine",
+				  "name": "old-debugger"
+				},
+				{
+				  "image": "busybox",
+				  "name": "debugger"
+				}
+			  ]
+			}
+		  },
+		  "oldObject": {
+			"metadata": {
+			  "name": "myapp",
+			  "namespace": "foobar"
+			},
+			"spec": {
+			  "containers": [
+				{
+				  "image": "nginx",
+				  "name": "nginx-frontend"
+				}
+			  ],
+			  "ephemeralContainers": [
+				{
+				  "image": "alpine",
+				  "name": "old-debugger"
+				}
+			  ]
+			}
+		  }
+		}
+	  }`
 	whitelistedAdmissionRequest = `
 	  {
 		  "kind": "AdmissionReview",
 		  "request": {
+			"uid": "44444444-6393-11e8-b7cc-42010a800002",
 			"kind": {
 			  "kind": "Pod",
 			  "version": "v1"
@@ -205,7 +429,7 @@ var (
 			reqPath:      "/mutate",
 			reqBody:      string(untrustedAdmissionRequest),
 			expectStatus: http.StatusOK,
-			expectBody:   `{"response":{"uid":"","allowed":true,"patch":"W3sib3AiOiJhZGQiLCJwYXRoIjoiL21ldGFkYXRhL2Fubm90YXRpb25zIiwidmFsdWUiOnt9fSx7Im9wIjoicmVwbGFjZSIsInBhdGgiOiIvc3BlYy9jb250YWluZXJzLzAvaW1hZ2UiLCJ2YWx1ZSI6InByaXZhdGUtcmVnaXN0cnkuY2x1c3Rlci5sb2NhbC9uZ2lueCJ9LHsib3AiOiJhZGQiLCJwYXRoIjoiL21ldGFkYXRhL2Fubm90YXRpb25zL3R1Z2dlci1vcmlnaW5hbC1pbWFnZS0wIiwidmFsdWUiOiJuZ2lueCJ9LHsib3AiOiJyZXBsYWNlIiwicGF0aCI6Ii9zcGVjL2NvbnRhaW5lcnMvMS9pbWFnZSIsInZhbHVlIjoicHJpdmF0ZS1yZWdpc3RyeS5jbHVzdGVyLmxvY2FsL215c3FsIn0seyJvcCI6ImFkZCIsInBhdGgiOiIvbWV0YWRhdGEvYW5ub3RhdGlvbnMvdHVnZ2VyLW9yaWdpbmFsLWltYWdlLTEiLCJ2YWx1ZSI6Im15c3FsIn0seyJvcCI6InJlcGxhY2UiLCJwYXRoIjoiL3NwZWMvaW5pdENvbnRhaW5lcnMvMC9pbWFnZSIsInZhbHVlIjoicHJpdmF0ZS1yZWdpc3RyeS5jbHVzdGVyLmxvY2FsL25naW54In0seyJvcCI6ImFkZCIsInBhdGgiOiIvbWV0YWRhdGEvYW5ub3RhdGlvbnMvdHVnZ2VyLW9yaWdpbmFsLWluaXQtaW1hZ2UtMCIsInZhbHVlIjoibmdpbngifSx7Im9wIjoicmVwbGFjZSIsInBhdGgiOiIvc3BlYy9pbml0Q29udGFpbmVycy8xL2ltYWdlIiwidmFsdWUiOiJwcml2YXRlLXJlZ2lzdHJ5LmNsdXN0ZXIubG9jYWwvbXlzcWwifSx7Im9wIjoiYWRkIiwicGF0aCI6Ii9tZXRhZGF0YS9hbm5vdGF0aW9ucy90dWdnZXItb3JpZ2luYWwtaW5pdC1pbWFnZS0xIiwidmFsdWUiOiJteXNxbCJ9LHsib3AiOiJhZGQiLCJwYXRoIjoiL21ldGFkYXRhL2xhYmVscyIsInZhbHVlIjp7fX0seyJvcCI6ImFkZCIsInBhdGgiOiIvbWV0YWRhdGEvbGFiZWxzL3R1Z2dlci1tb2RpZmllZCIsInZhbHVlIjoidHJ1ZSJ9XQ==","patchType":"JSONPatch"}}`,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"22222222-6393-11e8-b7cc-42010a800002","allowed":true,"patch":"W3sib3AiOiJhZGQiLCJwYXRoIjoiL21ldGFkYXRhL2Fubm90YXRpb25zIiwidmFsdWUiOnt9fSx7Im9wIjoicmVwbGFjZSIsInBhdGgiOiIvc3BlYy9jb250YWluZXJzLzAvaW1hZ2UiLCJ2YWx1ZSI6InByaXZhdGUtcmVnaXN0cnkuY2x1c3Rlci5sb2NhbC9uZ2lueCJ9LHsib3AiOiJhZGQiLCJwYXRoIjoiL21ldGFkYXRhL2Fubm90YXRpb25zL3R1Z2dlci1vcmlnaW5hbC1pbWFnZS0wIiwidmFsdWUiOiJuZ2lueCJ9LHsib3AiOiJyZXBsYWNlIiwicGF0aCI6Ii9zcGVjL2NvbnRhaW5lcnMvMS9pbWFnZSIsInZhbHVlIjoicHJpdmF0ZS1yZWdpc3RyeS5jbHVzdGVyLmxvY2FsL215c3FsIn0seyJvcCI6ImFkZCIsInBhdGgiOiIvbWV0YWRhdGEvYW5ub3RhdGlvbnMvdHVnZ2VyLW9yaWdpbmFsLWltYWdlLTEiLCJ2YWx1ZSI6Im15c3FsIn0seyJvcCI6InJlcGxhY2UiLCJwYXRoIjoiL3NwZWMvaW5pdENvbnRhaW5lcnMvMC9pbWFnZSIsInZhbHVlIjoicHJpdmF0ZS1yZWdpc3RyeS5jbHVzdGVyLmxvY2FsL25naW54In0seyJvcCI6ImFkZCIsInBhdGgiOiIvbWV0YWRhdGEvYW5ub3RhdGlvbnMvdHVnZ2VyLW9yaWdpbmFsLWluaXQtaW1hZ2UtMCIsInZhbHVlIjoibmdpbngifSx7Im9wIjoicmVwbGFjZSIsInBhdGgiOiIvc3BlYy9pbml0Q29udGFpbmVycy8xL2ltYWdlIiwidmFsdWUiOiJwcml2YXRlLXJlZ2lzdHJ5LmNsdXN0ZXIubG9jYWwvbXlzcWwifSx7Im9wIjoiYWRkIiwicGF0aCI6Ii9tZXRhZGF0YS9hbm5vdGF0aW9ucy90dWdnZXItb3JpZ2luYWwtaW5pdC1pbWFnZS0xIiwidmFsdWUiOiJteXNxbCJ9LHsib3AiOiJhZGQiLCJwYXRoIjoiL21ldGFkYXRhL2xhYmVscyIsInZhbHVlIjp7fX0seyJvcCI6ImFkZCIsInBhdGgiOiIvbWV0YWRhdGEvbGFiZWxzL3R1Z2dlci1tb2RpZmllZCIsInZhbHVlIjoidHJ1ZSJ9XQ==","patchType":"JSONPatch"}}`,
 		},
 		{
 			name:         "mutate/trusted",
@@ -214,7 +438,7 @@ var (
 			reqPath:      "/mutate",
 			reqBody:      string(trustedAdmissionRequest),
 			expectStatus: http.StatusOK,
-			expectBody:   `{"response":{"uid":"","allowed":true}}`,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"11111111-6393-11e8-b7cc-42010a800002","allowed":true}}`,
 		},
 		{
 			name:         "mutate/whitelisted",
@@ -223,7 +447,60 @@ var (
 			reqPath:      "/mutate",
 			reqBody:      string(whitelistedAdmissionRequest),
 			expectStatus: http.StatusOK,
-			expectBody:   `{"response":{"uid":"","allowed":true}}`,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"44444444-6393-11e8-b7cc-42010a800002","allowed":true}}`,
+		},
+		{
+			name:         "mutate/v1",
+			handler:      mutateAdmissionReviewHandler,
+			reqMethod:    "POST",
+			reqPath:      "/mutate",
+			reqBody:      string(untrustedAdmissionRequestV1),
+			expectStatus: http.StatusOK,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"705ab4f5-6393-11e8-b7cc-42010a800002","allowed":true,"patch":"W3sib3AiOiJhZGQiLCJwYXRoIjoiL21ldGFkYXRhL2Fubm90YXRpb25zIiwidmFsdWUiOnt9fSx7Im9wIjoicmVwbGFjZSIsInBhdGgiOiIvc3BlYy9jb250YWluZXJzLzAvaW1hZ2UiLCJ2YWx1ZSI6InByaXZhdGUtcmVnaXN0cnkuY2x1c3Rlci5sb2NhbC9uZ2lueCJ9LHsib3AiOiJhZGQiLCJwYXRoIjoiL21ldGFkYXRhL2Fubm90YXRpb25zL3R1Z2dlci1vcmlnaW5hbC1pbWFnZS0wIiwidmFsdWUiOiJuZ2lueCJ9LHsib3AiOiJhZGQiLCJwYXRoIjoiL21ldGFkYXRhL2xhYmVscyIsInZhbHVlIjp7fX0seyJvcCI6ImFkZCIsInBhdGgiOiIvbWV0YWRhdGEvbGFiZWxzL3R1Z2dlci1tb2RpZmllZCIsInZhbHVlIjoidHJ1ZSJ9XQ==","patchType":"JSONPatch"}}`,
+		},
+		{
+			name:         "mutate/deployment",
+			handler:      mutateAdmissionReviewHandler,
+			reqMethod:    "POST",
+			reqPath:      "/mutate",
+			reqBody:      string(untrustedDeploymentAdmissionRequest),
+			expectStatus: http.StatusOK,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"55555555-6393-11e8-b7cc-42010a800002","allowed":true,"patch":"W3sib3AiOiJhZGQiLCJwYXRoIjoiL3NwZWMvdGVtcGxhdGUvbWV0YWRhdGEvYW5ub3RhdGlvbnMiLCJ2YWx1ZSI6e319LHsib3AiOiJyZXBsYWNlIiwicGF0aCI6Ii9zcGVjL3RlbXBsYXRlL3NwZWMvY29udGFpbmVycy8wL2ltYWdlIiwidmFsdWUiOiJwcml2YXRlLXJlZ2lzdHJ5LmNsdXN0ZXIubG9jYWwvbmdpbngifSx7Im9wIjoiYWRkIiwicGF0aCI6Ii9zcGVjL3RlbXBsYXRlL21ldGFkYXRhL2Fubm90YXRpb25zL3R1Z2dlci1vcmlnaW5hbC1pbWFnZS0wIiwidmFsdWUiOiJuZ2lueCJ9LHsib3AiOiJhZGQiLCJwYXRoIjoiL3NwZWMvdGVtcGxhdGUvbWV0YWRhdGEvbGFiZWxzL3R1Z2dlci1tb2RpZmllZCIsInZhbHVlIjoidHJ1ZSJ9XQ==","patchType":"JSONPatch"}}`,
+		},
+		{
+			name:         "mutate/owned-replicaset",
+			handler:      mutateAdmissionReviewHandler,
+			reqMethod:    "POST",
+			reqPath:      "/mutate",
+			reqBody:      string(untrustedOwnedReplicaSetAdmissionRequest),
+			expectStatus: http.StatusOK,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"88888888-6393-11e8-b7cc-42010a800002","allowed":true}}`,
+		},
+		{
+			name:         "mutate/cronjob",
+			handler:      mutateAdmissionReviewHandler,
+			reqMethod:    "POST",
+			reqPath:      "/mutate",
+			reqBody:      string(untrustedCronJobAdmissionRequest),
+			expectStatus: http.StatusOK,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"66666666-6393-11e8-b7cc-42010a800002","allowed":true,"patch":"W3sib3AiOiJhZGQiLCJwYXRoIjoiL3NwZWMvam9iVGVtcGxhdGUvc3BlYy90ZW1wbGF0ZS9tZXRhZGF0YSIsInZhbHVlIjp7fX0seyJvcCI6ImFkZCIsInBhdGgiOiIvc3BlYy9qb2JUZW1wbGF0ZS9zcGVjL3RlbXBsYXRlL21ldGFkYXRhL2Fubm90YXRpb25zIiwidmFsdWUiOnt9fSx7Im9wIjoicmVwbGFjZSIsInBhdGgiOiIvc3BlYy9qb2JUZW1wbGF0ZS9zcGVjL3RlbXBsYXRlL3NwZWMvY29udGFpbmVycy8xL2ltYWdlIiwidmFsdWUiOiJwcml2YXRlLXJlZ2lzdHJ5LmNsdXN0ZXIubG9jYWwvbXlzcWwifSx7Im9wIjoiYWRkIiwicGF0aCI6Ii9zcGVjL2pvYlRlbXBsYXRlL3NwZWMvdGVtcGxhdGUvbWV0YWRhdGEvYW5ub3RhdGlvbnMvdHVnZ2VyLW9yaWdpbmFsLWltYWdlLTEiLCJ2YWx1ZSI6Im15c3FsIn0seyJvcCI6ImFkZCIsInBhdGgiOiIvc3BlYy9qb2JUZW1wbGF0ZS9zcGVjL3RlbXBsYXRlL21ldGFkYXRhL2xhYmVscyIsInZhbHVlIjp7fX0seyJvcCI6ImFkZCIsInBhdGgiOiIvc3BlYy9qb2JUZW1wbGF0ZS9zcGVjL3RlbXBsYXRlL21ldGFkYXRhL2xhYmVscy90dWdnZXItbW9kaWZpZWQiLCJ2YWx1ZSI6InRydWUifV0=","patchType":"JSONPatch"}}`,
+		},
+		{
+			name:         "mutate/ephemeral",
+			handler:      mutateAdmissionReviewHandler,
+			reqMethod:    "POST",
+			reqPath:      "/mutate",
+			reqBody:      string(untrustedEphemeralAdmissionRequest),
+			expectStatus: http.StatusOK,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"77777777-6393-11e8-b7cc-42010a800002","allowed":true,"patch":"W3sib3AiOiJyZXBsYWNlIiwicGF0aCI6Ii9zcGVjL2VwaGVtZXJhbENvbnRhaW5lcnMvMS9pbWFnZSIsInZhbHVlIjoicHJpdmF0ZS1yZWdpc3RyeS5jbHVzdGVyLmxvY2FsL2J1c3lib3gifV0=","patchType":"JSONPatch"}}`,
+		},
+		{
+			name:         "validate/empty",
+			handler:      validateAdmissionReviewHandler,
+			reqMethod:    "POST",
+			reqPath:      "/validate",
+			reqBody:      `{}`,
+			expectStatus: http.StatusBadRequest,
 		},
 		{
 			name:         "validate/untrusted",
@@ -232,7 +509,52 @@ var (
 			reqPath:      "/validate",
 			reqBody:      string(untrustedAdmissionRequest),
 			expectStatus: http.StatusOK,
-			expectBody:   `{"response":{"uid":"","allowed":false,"status":{"metadata":{},"reason":"Invalid","details":{"causes":[{"message":"Image is not being pulled from Private Registry: nginx"}]}}}}`,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"22222222-6393-11e8-b7cc-42010a800002","allowed":false,"status":{"metadata":{},"message":"container nginx-frontend: Image is not being pulled from Private Registry: nginx (rules: ` + ruleSetPlaceholder + `); container mysql-backend: Image is not being pulled from Private Registry: mysql (rules: ` + ruleSetPlaceholder + `); init container nginx-frontend: Image is not being pulled from Private Registry: nginx (rules: ` + ruleSetPlaceholder + `); init container mysql-backend: Image is not being pulled from Private Registry: mysql (rules: ` + ruleSetPlaceholder + `)","reason":"Invalid","details":{"causes":[{"reason":"FieldValueInvalid","message":"container nginx-frontend: Image is not being pulled from Private Registry: nginx (rules: ` + ruleSetPlaceholder + `)","field":"spec.containers[0].image"},{"reason":"FieldValueInvalid","message":"container mysql-backend: Image is not being pulled from Private Registry: mysql (rules: ` + ruleSetPlaceholder + `)","field":"spec.containers[1].image"},{"reason":"FieldValueInvalid","message":"init container nginx-frontend: Image is not being pulled from Private Registry: nginx (rules: ` + ruleSetPlaceholder + `)","field":"spec.initContainers[0].image"},{"reason":"FieldValueInvalid","message":"init container mysql-backend: Image is not being pulled from Private Registry: mysql (rules: ` + ruleSetPlaceholder + `)","field":"spec.initContainers[1].image"}]}}}}`,
+		},
+		{
+			name:         "validate/v1",
+			handler:      validateAdmissionReviewHandler,
+			reqMethod:    "POST",
+			reqPath:      "/validate",
+			reqBody:      string(untrustedAdmissionRequestV1),
+			expectStatus: http.StatusOK,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"705ab4f5-6393-11e8-b7cc-42010a800002","allowed":false,"status":{"metadata":{},"message":"container nginx-frontend: Image is not being pulled from Private Registry: nginx (rules: ` + ruleSetPlaceholder + `)","reason":"Invalid","details":{"causes":[{"reason":"FieldValueInvalid","message":"container nginx-frontend: Image is not being pulled from Private Registry: nginx (rules: ` + ruleSetPlaceholder + `)","field":"spec.containers[0].image"}]}}}}`,
+		},
+		{
+			name:         "validate/deployment",
+			handler:      validateAdmissionReviewHandler,
+			reqMethod:    "POST",
+			reqPath:      "/validate",
+			reqBody:      string(untrustedDeploymentAdmissionRequest),
+			expectStatus: http.StatusOK,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"55555555-6393-11e8-b7cc-42010a800002","allowed":false,"status":{"metadata":{},"message":"container nginx-frontend: Image is not being pulled from Private Registry: nginx (rules: ` + ruleSetPlaceholder + `)","reason":"Invalid","details":{"causes":[{"reason":"FieldValueInvalid","message":"container nginx-frontend: Image is not being pulled from Private Registry: nginx (rules: ` + ruleSetPlaceholder + `)","field":"spec.template.spec.containers[0].image"}]}}}}`,
+		},
+		{
+			name:         "validate/owned-replicaset",
+			handler:      validateAdmissionReviewHandler,
+			reqMethod:    "POST",
+			reqPath:      "/validate",
+			reqBody:      string(untrustedOwnedReplicaSetAdmissionRequest),
+			expectStatus: http.StatusOK,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"88888888-6393-11e8-b7cc-42010a800002","allowed":true}}`,
+		},
+		{
+			name:         "validate/cronjob",
+			handler:      validateAdmissionReviewHandler,
+			reqMethod:    "POST",
+			reqPath:      "/validate",
+			reqBody:      string(untrustedCronJobAdmissionRequest),
+			expectStatus: http.StatusOK,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"66666666-6393-11e8-b7cc-42010a800002","allowed":false,"status":{"metadata":{},"message":"container mysql-backend: Image is not being pulled from Private Registry: mysql (rules: ` + ruleSetPlaceholder + `)","reason":"Invalid","details":{"causes":[{"reason":"FieldValueInvalid","message":"container mysql-backend: Image is not being pulled from Private Registry: mysql (rules: ` + ruleSetPlaceholder + `)","field":"spec.jobTemplate.spec.template.spec.containers[1].image"}]}}}}`,
+		},
+		{
+			name:         "validate/ephemeral",
+			handler:      validateAdmissionReviewHandler,
+			reqMethod:    "POST",
+			reqPath:      "/validate",
+			reqBody:      string(untrustedEphemeralAdmissionRequest),
+			expectStatus: http.StatusOK,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"77777777-6393-11e8-b7cc-42010a800002","allowed":false,"status":{"metadata":{},"message":"ephemeral container debugger: Image is not being pulled from Private Registry: busybox (rules: ` + ruleSetPlaceholder + `)","reason":"Invalid","details":{"causes":[{"reason":"FieldValueInvalid","message":"ephemeral container debugger: Image is not being pulled from Private Registry: busybox (rules: ` + ruleSetPlaceholder + `)","field":"spec.ephemeralContainers[1].image"}]}}}}`,
 		},
 		{
 			name:         "validate/mixedtrust",
@@ -241,7 +563,7 @@ var (
 			reqPath:      "/validate",
 			reqBody:      string(mixedTrustAdmissionRequest),
 			expectStatus: http.StatusOK,
-			expectBody:   `{"response":{"uid":"","allowed":false,"status":{"metadata":{},"reason":"Invalid","details":{"causes":[{"message":"Image is not being pulled from Private Registry: mysql"}]}}}}`,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"33333333-6393-11e8-b7cc-42010a800002","allowed":false,"status":{"metadata":{},"message":"init container mysql-backend: Image is not being pulled from Private Registry: mysql (rules: ` + ruleSetPlaceholder + `)","reason":"Invalid","details":{"causes":[{"reason":"FieldValueInvalid","message":"init container mysql-backend: Image is not being pulled from Private Registry: mysql (rules: ` + ruleSetPlaceholder + `)","field":"spec.initContainers[1].image"}]}}}}`,
 		},
 		{
 			name:         "validate/trusted",
@@ -250,7 +572,7 @@ var (
 			reqPath:      "/validate",
 			reqBody:      string(trustedAdmissionRequest),
 			expectStatus: http.StatusOK,
-			expectBody:   `{"response":{"uid":"","allowed":true}}`,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"11111111-6393-11e8-b7cc-42010a800002","allowed":true}}`,
 		},
 		{
 			name:         "validate/whitelisted",
@@ -259,7 +581,7 @@ var (
 			reqPath:      "/validate",
 			reqBody:      string(whitelistedAdmissionRequest),
 			expectStatus: http.StatusOK,
-			expectBody:   `{"response":{"uid":"","allowed":true}}`,
+			expectBody:   `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"44444444-6393-11e8-b7cc-42010a800002","allowed":true}}`,
 		},
 	}
 )
@@ -268,7 +590,7 @@ func TestHandlerLegacy(t *testing.T) {
 	dockerRegistryUrl = trustedRegistry
 	whitelistRegistries = dockerRegistryUrl
 	whitelistedRegistries = strings.Split(whitelistRegistries, ",")
-	testHandler(t)
+	testHandler(t, "WHITELIST_REGISTRIES")
 }
 
 func TestHandlerPolicy(t *testing.T) {
@@ -282,10 +604,216 @@ rules:
 	defer func() {
 		policy = nil
 	}()
-	testHandler(t)
+	testHandler(t, "policy")
+}
+
+func TestHandlerEnforcementModes(t *testing.T) {
+	whitelistNamespaces = "kube-system"
+	whitelistedNamespaces = strings.Split(whitelistNamespaces, ",")
+	tests := []struct {
+		name       string
+		mode       string
+		expectBody string
+		// wantNotifications is the number of notifications sent for the request
+		wantNotifications int
+	}{
+		{
+			name:              "enforce",
+			mode:              modeEnforce,
+			wantNotifications: 1,
+			expectBody:        `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"33333333-6393-11e8-b7cc-42010a800002","allowed":false,"status":{"metadata":{},"message":"init container mysql-backend: Image is not being pulled from Private Registry: mysql (rules: policy)","reason":"Invalid","details":{"causes":[{"reason":"FieldValueInvalid","message":"init container mysql-backend: Image is not being pulled from Private Registry: mysql (rules: policy)","field":"spec.initContainers[1].image"}]}}}}`,
+		},
+		{
+			name:       "warn",
+			mode:       modeWarn,
+			expectBody: `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"33333333-6393-11e8-b7cc-42010a800002","allowed":true,"warnings":["init container mysql-backend: Image is not being pulled from Private Registry: mysql (rules: policy)"]}}`,
+		},
+		{
+			name:       "audit",
+			mode:       modeAudit,
+			expectBody: `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"33333333-6393-11e8-b7cc-42010a800002","allowed":true,"auditAnnotations":{"would-deny":"init container mysql-backend: Image is not being pulled from Private Registry: mysql (rules: policy)"}}}`,
+		},
+	}
+	defer func() {
+		policy = nil
+		notifier = nil
+	}()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := &recordingNotifier{}
+			notifier = recorder
+			policy, _ = NewPolicy()
+			if err := policy.Load([]byte(`
+namespaceModes:
+  foobar: ` + tt.mode + `
+rules:
+- pattern: ^` + trustedRegistry + `/.*
+`)); err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest("POST", "/validate", strings.NewReader(mixedTrustAdmissionRequest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(validateAdmissionReviewHandler).ServeHTTP(rr, req)
+
+			if rr.Body.String() != tt.expectBody {
+				t.Errorf("handler returned unexpected body: got %v want %v",
+					rr.Body.String(), tt.expectBody)
+			}
+			if len(recorder.sent) != tt.wantNotifications {
+				t.Errorf("handler sent %d notifications, want %d", len(recorder.sent), tt.wantNotifications)
+			}
+		})
+	}
+}
+
+func TestHandlerDenyRules(t *testing.T) {
+	whitelistNamespaces = "kube-system"
+	whitelistedNamespaces = strings.Split(whitelistNamespaces, ",")
+	tests := []struct {
+		name       string
+		rules      string
+		expectBody string
+	}{
+		{
+			name: "message",
+			rules: `
+- pattern: ^mysql(:.*)?$
+  action: deny
+  message: mysql is not supported, use ` + trustedRegistry + `/mysql
+- pattern: .*
+`,
+			expectBody: `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"33333333-6393-11e8-b7cc-42010a800002","allowed":false,"status":{"metadata":{},"message":"init container mysql-backend: Image is denied: mysql: mysql is not supported, use ` + trustedRegistry + `/mysql (rules: policy)","reason":"Invalid","details":{"causes":[{"reason":"FieldValueInvalid","message":"init container mysql-backend: Image is denied: mysql: mysql is not supported, use ` + trustedRegistry + `/mysql (rules: policy)","field":"spec.initContainers[1].image"}]}}}}`,
+		},
+		{
+			name: "no message",
+			rules: `
+- repository: library/mysql
+  action: deny
+- pattern: .*
+`,
+			expectBody: `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"33333333-6393-11e8-b7cc-42010a800002","allowed":false,"status":{"metadata":{},"message":"init container mysql-backend: Image is denied: mysql: denied by rule 1 (rules: policy)","reason":"Invalid","details":{"causes":[{"reason":"FieldValueInvalid","message":"init container mysql-backend: Image is denied: mysql: denied by rule 1 (rules: policy)","field":"spec.initContainers[1].image"}]}}}}`,
+		},
+		{
+			name: "constraints",
+			rules: `
+- pattern: ^mysql(:.*)?$
+  constraints:
+    forbidLatest: true
+- pattern: .*
+`,
+			expectBody: `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"33333333-6393-11e8-b7cc-42010a800002","allowed":false,"status":{"metadata":{},"message":"init container mysql-backend: Image is denied: mysql: image has no tag, which defaults to the mutable latest tag (rules: policy)","reason":"Invalid","details":{"causes":[{"reason":"FieldValueInvalid","message":"init container mysql-backend: Image is denied: mysql: image has no tag, which defaults to the mutable latest tag (rules: policy)","field":"spec.initContainers[1].image"}]}}}}`,
+		},
+		{
+			name: "allowed first",
+			rules: `
+- pattern: .*
+- pattern: ^mysql(:.*)?$
+  action: deny
+`,
+			expectBody: `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1beta1","response":{"uid":"33333333-6393-11e8-b7cc-42010a800002","allowed":true}}`,
+		},
+	}
+	defer func() {
+		policy = nil
+	}()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, _ = NewPolicy()
+			if err := policy.Load([]byte("rules:" + tt.rules)); err != nil {
+				t.Fatal(err)
+			}
+
+			req, err := http.NewRequest("POST", "/validate", strings.NewReader(mixedTrustAdmissionRequest))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(validateAdmissionReviewHandler).ServeHTTP(rr, req)
+
+			if rr.Body.String() != tt.expectBody {
+				t.Errorf("handler returned unexpected body: got %v want %v",
+					rr.Body.String(), tt.expectBody)
+			}
+		})
+	}
+}
+
+func TestHandlerRegistryUnreachable(t *testing.T) {
+	whitelistNamespaces = "kube-system"
+	whitelistedNamespaces = strings.Split(whitelistNamespaces, ",")
+	dockerRegistryUrl = trustedRegistry
+	whitelistedRegistries = registryWhitelist{trustedRegistry}
+	defaultLookup, defaultUnreachable := lookupImage, registryUnreachable
+	lookupImage = registryStub{Unreachable: []string{trustedRegistry + "/nginx", "mirror/nginx"}}.lookup
+	registryUnreachable = unreachableDeny
+	defer func() {
+		lookupImage, registryUnreachable = defaultLookup, defaultUnreachable
+		policy = nil
+		ifExists = false
+	}()
+
+	tests := []struct {
+		name       string
+		policy     string
+		expectBody string
+	}{
+		{
+			name:       "legacy",
+			expectBody: `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"705ab4f5-6393-11e8-b7cc-42010a800002","allowed":false,"status":{"metadata":{},"message":"container nginx-frontend: Image is denied: nginx: could not verify that ` + trustedRegistry + `/nginx exists, its registry is unreachable (rules: WHITELIST_REGISTRIES)","reason":"Invalid","details":{"causes":[{"reason":"FieldValueInvalid","message":"container nginx-frontend: Image is denied: nginx: could not verify that ` + trustedRegistry + `/nginx exists, its registry is unreachable (rules: WHITELIST_REGISTRIES)","field":"spec.containers[0].image"}]}}}}`,
+		},
+		{
+			name: "policy",
+			policy: `
+rules:
+- pattern: (.*)
+  replacement: mirror/$1
+  condition: Exists
+`,
+			expectBody: `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"705ab4f5-6393-11e8-b7cc-42010a800002","allowed":false,"status":{"metadata":{},"message":"container nginx-frontend: Image is denied: nginx: could not verify that mirror/nginx exists, its registry is unreachable (rules: policy)","reason":"Invalid","details":{"causes":[{"reason":"FieldValueInvalid","message":"container nginx-frontend: Image is denied: nginx: could not verify that mirror/nginx exists, its registry is unreachable (rules: policy)","field":"spec.containers[0].image"}]}}}}`,
+		},
+		{
+			name: "warn mode",
+			policy: `
+mode: warn
+rules:
+- pattern: (.*)
+  replacement: mirror/$1
+  condition: Exists
+`,
+			expectBody: `{"kind":"AdmissionReview","apiVersion":"admission.k8s.io/v1","response":{"uid":"705ab4f5-6393-11e8-b7cc-42010a800002","allowed":true,"warnings":["container nginx-frontend: Image is denied: nginx: could not verify that mirror/nginx exists, its registry is unreachable (rules: policy)"]}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, ifExists = nil, true
+			if tt.policy != "" {
+				policy, _ = NewPolicy()
+				if err := policy.Load([]byte(tt.policy)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			req, err := http.NewRequest("POST", "/mutate", strings.NewReader(untrustedAdmissionRequestV1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			http.HandlerFunc(mutateAdmissionReviewHandler).ServeHTTP(rr, req)
+
+			if rr.Body.String() != tt.expectBody {
+				t.Errorf("handler returned unexpected body: got %v want %v",
+					rr.Body.String(), tt.expectBody)
+			}
+		})
+	}
 }
 
-func testHandler(t *testing.T) {
+// testHandler runs testCases, ruleSet is substituted for ruleSetPlaceholder in the expected bodies
+func testHandler(t *testing.T, ruleSet string) {
 	whitelistNamespaces = "kube-system"
 	whitelistedNamespaces = strings.Split(whitelistNamespaces, ",")
 	for _, tt := range testCases {
@@ -307,9 +835,10 @@ func testHandler(t *testing.T) {
 			}
 
 			// Check the response body is what we expect.
-			if rr.Body.String() != tt.expectBody {
+			expectBody := strings.ReplaceAll(tt.expectBody, ruleSetPlaceholder, ruleSet)
+			if rr.Body.String() != expectBody {
 				t.Errorf("handler returned unexpected body: got %v want %v",
-					rr.Body.String(), tt.expectBody)
+					rr.Body.String(), expectBody)
 			}
 		})
 	}
@@ -317,15 +846,24 @@ func testHandler(t *testing.T) {
 
 func runMockRegistry() func() {
 	httpmock.Activate()
+	registryTransport = httpmock.DefaultTransport
+	manifest := func(req *http.Request) (*http.Response, error) {
+		resp := httpmock.NewStringResponse(http.StatusOK, "")
+		resp.Header.Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+		resp.Header.Set("Docker-Content-Digest", "sha256:2d4f6d8aec48cf4e5b9a5e9d4e8f5d5c8a4b8f3d3c1e0f4e1a5a8f6b7c9d0e1f")
+		resp.ContentLength = 0
+		return resp, nil
+	}
 	httpmock.RegisterResponder("GET", "https://index.docker.io/v2/",
 		httpmock.NewStringResponder(http.StatusOK, `{}`))
-	httpmock.RegisterResponder("GET", "https://index.docker.io/v2/library/nginx/manifests/latest",
-		httpmock.NewStringResponder(http.StatusOK, `{}`))
-	httpmock.RegisterResponder("GET", "https://index.docker.io/v2/jainishshah17/nginx/manifests/latest",
-		httpmock.NewStringResponder(http.StatusOK, `{}`))
-	httpmock.RegisterResponder("GET", "https://index.docker.io/v2/jainishshah17/nginx/manifests/notexist",
-		httpmock.NewStringResponder(http.StatusNotFound, `{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown","detail":{"Tag":"notexist"}}]}`))
-	return httpmock.DeactivateAndReset
+	httpmock.RegisterResponder("HEAD", "https://index.docker.io/v2/library/nginx/manifests/latest", manifest)
+	httpmock.RegisterResponder("HEAD", "https://index.docker.io/v2/jainishshah17/nginx/manifests/latest", manifest)
+	httpmock.RegisterResponder("HEAD", "https://index.docker.io/v2/jainishshah17/nginx/manifests/notexist",
+		httpmock.NewStringResponder(http.StatusNotFound, ``))
+	return func() {
+		httpmock.DeactivateAndReset()
+		registryTransport = remote.DefaultTransport
+	}
 }
 
 func Test_imageExists(t *testing.T) {
@@ -353,7 +891,7 @@ func Test_imageExists(t *testing.T) {
 	defer runMockRegistry()()
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := imageExists(tt.image); got != tt.want {
+			if got, _ := imageExists(tt.image, nil); got != tt.want {
 				t.Errorf("imageExists() = %v, want %v", got, tt.want)
 			}
 		})
@@ -369,74 +907,6 @@ func runMockSlack() func() {
 	return httpmock.DeactivateAndReset
 }
 
-func TestSendSlackNotification(t *testing.T) {
-	defaultEnv := env
-	defaultSlackDupeCache := slackDupeCache
-	defaultWebhookURL := webhookUrl
-	sharedDupeCache := cache.New(time.Minute, time.Minute)
-	tests := []struct {
-		name           string
-		msg            string
-		env            string
-		slackDupeCache *cache.Cache
-		webhookURL     string
-	}{
-		{
-			name:       "disabled",
-			webhookURL: "",
-		},
-		{
-			name:       "happy",
-			msg:        "foo does not exist in private registry",
-			webhookURL: mockSlackURL,
-		},
-		{
-			name:       "with env",
-			msg:        "foo does not exist in private registry",
-			env:        "dev-1",
-			webhookURL: mockSlackURL,
-		},
-		{
-			name:           "with dupe cache miss",
-			msg:            "foo does not exist in private registry",
-			slackDupeCache: sharedDupeCache,
-			webhookURL:     mockSlackURL,
-		},
-		{
-			name:           "with dupe cache hit",
-			msg:            "foo does not exist in private registry",
-			slackDupeCache: sharedDupeCache,
-			webhookURL:     mockSlackURL,
-		},
-		{
-			name:       "slack connection error",
-			webhookURL: "example.com",
-		},
-		{
-			name:       "slack response error",
-			webhookURL: mockSlackURL + "error",
-		},
-		{
-			name:       "build request error",
-			webhookURL: "://",
-		},
-	}
-	defer runMockSlack()()
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			env = tt.env
-			slackDupeCache = tt.slackDupeCache
-			webhookUrl = tt.webhookURL
-			defer func() {
-				env = defaultEnv
-				slackDupeCache = defaultSlackDupeCache
-				webhookUrl = defaultWebhookURL
-			}()
-			SendSlackNotification(tt.msg)
-		})
-	}
-}
-
 func init() {
 	log = logging.New("debug")
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+// Admission outcomes
+const (
+	outcomeAllowed = "allowed"
+	outcomeDenied  = "denied"
+	outcomeMutated = "mutated"
+	// outcomeWarned and outcomeAudited are admissions allowed despite rejected images, in warn and audit mode
+	outcomeWarned  = "warned"
+	outcomeAudited = "audited"
+)
+
+var (
+	admissionRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tugger_admission_requests_total",
+		Help: "Admission requests by handler, namespace and outcome.",
+	}, []string{"handler", "namespace", "outcome"})
+
+	ruleMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tugger_rule_matches_total",
+		Help: "Images decided by a policy rule, by rule set, rule position and action.",
+	}, []string{"rule_set", "rule", "action"})
+
+	registryLookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tugger_registry_lookup_duration_seconds",
+		Help:    "Registry lookups by operation (exists, digest) and result (found, missing, unreachable, error).",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+
+	notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tugger_notifications_total",
+		Help: "Notifications by notifier and result (success, failure, suppressed).",
+	}, []string{"notifier", "result"})
+
+	cacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "tugger_cache_requests_total",
+		Help: "Cache requests by cache and result (hit, miss).",
+	}, []string{"cache", "result"})
+)
+
+// admissionOutcome returns the outcome of an admission response for metrics
+func admissionOutcome(resp *admissionv1.AdmissionResponse) string {
+	switch {
+	case !resp.Allowed:
+		return outcomeDenied
+	case len(resp.Patch) > 0:
+		return outcomeMutated
+	case len(resp.Warnings) > 0:
+		return outcomeWarned
+	case resp.AuditAnnotations["would-deny"] != "":
+		return outcomeAudited
+	}
+	return outcomeAllowed
+}
+
+// observeRegistryLookup records the duration and result of a registry lookup started at start
+func observeRegistryLookup(operation, result string, start time.Time) {
+	registryLookupDuration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
+
+// countRuleMatch records that the rule at index i of the policy decided an image
+func countRuleMatch(p *Policy, i int, rule *Pattern) {
+	ruleMatches.WithLabelValues(p.RuleSet(), strconv.Itoa(i+1), rule.action()).Inc()
+}
+
+// String returns the name of the status used in metrics
+func (s imageStatus) String() string {
+	switch s {
+	case imageFound:
+		return "found"
+	case imageUnreachable:
+		return "unreachable"
+	}
+	return "missing"
+}
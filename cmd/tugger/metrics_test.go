@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+)
+
+func Test_admissionOutcome(t *testing.T) {
+	tests := []struct {
+		name string
+		resp admissionv1.AdmissionResponse
+		want string
+	}{
+		{name: "allowed", resp: admissionv1.AdmissionResponse{Allowed: true}, want: outcomeAllowed},
+		{name: "denied", resp: admissionv1.AdmissionResponse{Allowed: false}, want: outcomeDenied},
+		{name: "mutated", resp: admissionv1.AdmissionResponse{Allowed: true, Patch: []byte(`[]`)}, want: outcomeMutated},
+		{name: "warned", resp: admissionv1.AdmissionResponse{Allowed: true, Warnings: []string{"denied"}}, want: outcomeWarned},
+		{name: "audited", resp: admissionv1.AdmissionResponse{Allowed: true, AuditAnnotations: map[string]string{"would-deny": "denied"}}, want: outcomeAudited},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := admissionOutcome(&tt.resp); got != tt.want {
+				t.Errorf("admissionOutcome() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	whitelistNamespaces = "kube-system"
+	whitelistedNamespaces = strings.Split(whitelistNamespaces, ",")
+	policy, _ = NewPolicy()
+	if err := policy.Load([]byte(`
+rules:
+- pattern: ^mysql$
+  action: deny
+- pattern: .*
+`)); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		policy = nil
+	}()
+
+	denied := testutil.ToFloat64(admissionRequests.WithLabelValues("validate", "foobar", outcomeDenied))
+	denyRule := testutil.ToFloat64(ruleMatches.WithLabelValues("policy", "1", actionDeny))
+	allowRule := testutil.ToFloat64(ruleMatches.WithLabelValues("policy", "2", actionAllow))
+
+	req := httptest.NewRequest("POST", "/validate", strings.NewReader(mixedTrustAdmissionRequest))
+	http.HandlerFunc(validateAdmissionReviewHandler).ServeHTTP(httptest.NewRecorder(), req)
+
+	if got := testutil.ToFloat64(admissionRequests.WithLabelValues("validate", "foobar", outcomeDenied)) - denied; got != 1 {
+		t.Errorf("denied admissions increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(ruleMatches.WithLabelValues("policy", "1", actionDeny)) - denyRule; got != 1 {
+		t.Errorf("deny rule matches increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(ruleMatches.WithLabelValues("policy", "2", actionAllow)) - allowRule; got != 3 {
+		t.Errorf("allow rule matches increased by %v, want 3", got)
+	}
+
+	rr := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	for _, metric := range []string{
+		`tugger_admission_requests_total{handler="validate",namespace="foobar",outcome="denied"}`,
+		`tugger_rule_matches_total{action="deny",rule="1",rule_set="policy"}`,
+	} {
+		if !strings.Contains(rr.Body.String(), metric) {
+			t.Errorf("metrics do not contain %s", metric)
+		}
+	}
+}
+
+func TestMetrics_registryLookups(t *testing.T) {
+	existsCache = newImageCache(time.Minute, time.Minute, 10)
+	defer func() {
+		existsCache = nil
+	}()
+	defer runMockRegistry()()
+
+	hits := testutil.ToFloat64(cacheRequests.WithLabelValues("exists", "hit"))
+	misses := testutil.ToFloat64(cacheRequests.WithLabelValues("exists", "miss"))
+
+	imageExists("jainishshah17/nginx:notexist", nil)
+	imageExists("jainishshah17/nginx:notexist", nil)
+
+	if got := testutil.ToFloat64(cacheRequests.WithLabelValues("exists", "miss")) - misses; got != 1 {
+		t.Errorf("cache misses increased by %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(cacheRequests.WithLabelValues("exists", "hit")) - hits; got != 1 {
+		t.Errorf("cache hits increased by %v, want 1", got)
+	}
+	rr := httptest.NewRecorder()
+	promhttp.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if metric := `tugger_registry_lookup_duration_seconds_count{operation="exists",result="missing"}`; !strings.Contains(rr.Body.String(), metric) {
+		t.Errorf("metrics do not contain %s", metric)
+	}
+}
+
+func TestMetrics_notifications(t *testing.T) {
+	notifier = &slackNotifier{url: mockSlackURL}
+	defer func() {
+		notifier = nil
+	}()
+	defer runMockSlack()()
+
+	success := testutil.ToFloat64(notifications.WithLabelValues(notifierSlack, "success"))
+	sendNotification("metrics test")
+	if got := testutil.ToFloat64(notifications.WithLabelValues(notifierSlack, "success")) - success; got != 1 {
+		t.Errorf("successful notifications increased by %v, want 1", got)
+	}
+}
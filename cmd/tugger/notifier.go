@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/patrickmn/go-cache"
+)
+
+// Notifier kinds
+const (
+	notifierSlack       = "slack"
+	notifierTeams       = "teams"
+	notifierWebhook     = "webhook"
+	notifierCloudEvents = "cloudevents"
+)
+
+// defaultWebhookTemplate is the body of generic webhook notifications without --notifier-template
+const defaultWebhookTemplate = `{"message":{{ json .Message }},"env":{{ json .Env }},"time":{{ json .Time }}}`
+
+// cloudEventType is the type of the CloudEvents tugger sends
+const cloudEventType = "com.github.jainishshah17.tugger.notification"
+
+var (
+	// notifier sends notifications about rejected images, nil disables notifications
+	notifier Notifier
+	// notificationDupeCache drops repeat notifications while they are cached, nil disables it
+	notificationDupeCache *cache.Cache
+	notificationClient    = &http.Client{Timeout: 10 * time.Second}
+)
+
+// Notifier delivers a notification to a chat or event service
+type Notifier interface {
+	// Name identifies the notifier in logs and metrics
+	Name() string
+	Notify(n notification) error
+}
+
+// notification is a message about an image tugger rejected or could not rewrite
+type notification struct {
+	Message string
+	// Env is the ENV of the cluster tugger runs in
+	Env  string
+	Time time.Time
+}
+
+// Text returns the message prefixed with the env, for chat services
+func (n notification) Text() string {
+	if n.Env != "" {
+		return fmt.Sprintf("[%s] %s", n.Env, n.Message)
+	}
+	return n.Message
+}
+
+// newNotifier creates the notifier of a kind posting to url. bodyTemplate is the body of generic
+// webhook notifications, source the source of CloudEvents.
+func newNotifier(kind, url, bodyTemplate, source string) (Notifier, error) {
+	switch kind {
+	case notifierSlack:
+		return &slackNotifier{url: url}, nil
+	case notifierTeams:
+		return &teamsNotifier{url: url}, nil
+	case notifierWebhook:
+		return newWebhookNotifier(url, bodyTemplate)
+	case notifierCloudEvents:
+		return &cloudEventsNotifier{url: url, source: source}, nil
+	}
+	return nil, fmt.Errorf("notifier must be %s, %s, %s or %s, not %s", notifierSlack, notifierTeams, notifierWebhook, notifierCloudEvents, kind)
+}
+
+// sendNotification notifies msg, dropping it if it was notified within the dedupe TTL
+func sendNotification(msg string) {
+	if notifier == nil {
+		log.Debugln("Notification webhook URL is not provided")
+		return
+	}
+
+	n := notification{Message: msg, Env: env, Time: time.Now().UTC()}
+	key := n.Text()
+	if notificationDupeCache != nil {
+		if err := notificationDupeCache.Add(key, struct{}{}, cache.DefaultExpiration); err != nil {
+			log.WithField("notifier", notifier.Name()).Info("suppressing duplicate notification")
+			notifications.WithLabelValues(notifier.Name(), "suppressed").Inc()
+			return
+		}
+	}
+
+	if err := notifier.Notify(n); err != nil {
+		log.WithError(err).WithField("notifier", notifier.Name()).Error("could not send notification")
+		notifications.WithLabelValues(notifier.Name(), "failure").Inc()
+		if notificationDupeCache != nil {
+			notificationDupeCache.Delete(key)
+		}
+		return
+	}
+	notifications.WithLabelValues(notifier.Name(), "success").Inc()
+}
+
+// postJSON posts body to url with a content type, returning the response body of a 2xx response
+func postJSON(url, contentType string, body []byte) (string, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		return "", fmt.Errorf("unable to build request: %w", err)
+	}
+	req.Header.Add("Content-Type", contentType)
+
+	resp, err := notificationClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("non-ok response %d: %s", resp.StatusCode, data)
+	}
+	return string(data), nil
+}
+
+// slackNotifier posts to an 'Incoming Webhook' url setup in Slack Apps, the slack channel is
+// saved within Slack
+type slackNotifier struct {
+	url string
+}
+
+func (s *slackNotifier) Name() string { return notifierSlack }
+
+func (s *slackNotifier) Notify(n notification) error {
+	body, _ := json.Marshal(struct {
+		Text string `json:"text"`
+	}{Text: n.Text()})
+	resp, err := postJSON(s.url, "application/json", body)
+	if err != nil {
+		return err
+	}
+	if resp != "ok" {
+		return fmt.Errorf("non-ok response returned from Slack: %s", resp)
+	}
+	return nil
+}
+
+// teamsNotifier posts a message card to a Microsoft Teams incoming webhook
+type teamsNotifier struct {
+	url string
+}
+
+func (t *teamsNotifier) Name() string { return notifierTeams }
+
+func (t *teamsNotifier) Notify(n notification) error {
+	body, _ := json.Marshal(map[string]string{
+		"@type":    "MessageCard",
+		"@context": "https://schema.org/extensions",
+		"summary":  "Tugger",
+		"text":     n.Text(),
+	})
+	_, err := postJSON(t.url, "application/json", body)
+	return err
+}
+
+// webhookNotifier posts a JSON body rendered from a template with the fields of a notification
+type webhookNotifier struct {
+	url  string
+	body *template.Template
+}
+
+// newWebhookNotifier parses the body template, an empty template renders defaultWebhookTemplate
+func newWebhookNotifier(url, bodyTemplate string) (*webhookNotifier, error) {
+	if bodyTemplate == "" {
+		bodyTemplate = defaultWebhookTemplate
+	}
+	tpl, err := template.New("body").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			data, err := json.Marshal(v)
+			return string(data), err
+		},
+	}).Parse(bodyTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook body template: %w", err)
+	}
+	return &webhookNotifier{url: url, body: tpl}, nil
+}
+
+func (w *webhookNotifier) Name() string { return notifierWebhook }
+
+func (w *webhookNotifier) Notify(n notification) error {
+	var body strings.Builder
+	if err := w.body.Execute(&body, n); err != nil {
+		return fmt.Errorf("could not render webhook body: %w", err)
+	}
+	_, err := postJSON(w.url, "application/json", []byte(body.String()))
+	return err
+}
+
+// cloudEventsNotifier posts notifications as CloudEvents in structured content mode
+type cloudEventsNotifier struct {
+	url    string
+	source string
+}
+
+func (c *cloudEventsNotifier) Name() string { return notifierCloudEvents }
+
+func (c *cloudEventsNotifier) Notify(n notification) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              uuid.New().String(),
+		"source":          c.source,
+		"type":            cloudEventType,
+		"time":            n.Time.Format(time.RFC3339Nano),
+		"datacontenttype": "application/json",
+		"data": map[string]string{
+			"message": n.Message,
+			"env":     n.Env,
+		},
+	})
+	_, err := postJSON(c.url, "application/cloudevents+json", body)
+	return err
+}
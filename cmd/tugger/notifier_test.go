@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSendNotification(t *testing.T) {
+	defaultEnv := env
+	sharedDupeCache := cache.New(time.Minute, time.Minute)
+	tests := []struct {
+		name      string
+		msg       string
+		env       string
+		dupeCache *cache.Cache
+		notifier  Notifier
+		want      string
+	}{
+		{
+			name: "disabled",
+		},
+		{
+			name:     "happy",
+			msg:      "foo does not exist in private registry",
+			notifier: &slackNotifier{url: mockSlackURL},
+			want:     "success",
+		},
+		{
+			name:     "with env",
+			msg:      "foo does not exist in private registry",
+			env:      "dev-1",
+			notifier: &slackNotifier{url: mockSlackURL},
+			want:     "success",
+		},
+		{
+			name:      "with dupe cache miss",
+			msg:       "bar does not exist in private registry",
+			dupeCache: sharedDupeCache,
+			notifier:  &slackNotifier{url: mockSlackURL},
+			want:      "success",
+		},
+		{
+			name:      "with dupe cache hit",
+			msg:       "bar does not exist in private registry",
+			dupeCache: sharedDupeCache,
+			notifier:  &slackNotifier{url: mockSlackURL},
+			want:      "suppressed",
+		},
+		{
+			name:     "slack connection error",
+			notifier: &slackNotifier{url: "example.com"},
+			want:     "failure",
+		},
+		{
+			name:     "slack response error",
+			notifier: &slackNotifier{url: mockSlackURL + "error"},
+			want:     "failure",
+		},
+		{
+			name:     "build request error",
+			notifier: &slackNotifier{url: "://"},
+			want:     "failure",
+		},
+	}
+	defer runMockSlack()()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env = tt.env
+			notificationDupeCache = tt.dupeCache
+			notifier = tt.notifier
+			defer func() {
+				env = defaultEnv
+				notificationDupeCache = nil
+				notifier = nil
+			}()
+
+			var before float64
+			if tt.want != "" {
+				before = testutil.ToFloat64(notifications.WithLabelValues(notifierSlack, tt.want))
+			}
+			sendNotification(tt.msg)
+			if tt.want != "" {
+				if got := testutil.ToFloat64(notifications.WithLabelValues(notifierSlack, tt.want)) - before; got != 1 {
+					t.Errorf("%s notifications increased by %v, want 1", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestNotifiers(t *testing.T) {
+	at := time.Date(2026, 10, 18, 9, 0, 0, 0, time.UTC)
+	n := notification{Message: "mysql is denied", Env: "prod", Time: at}
+	tests := []struct {
+		name        string
+		kind        string
+		template    string
+		contentType string
+		want        map[string]interface{}
+		wantErr     bool
+	}{
+		{
+			name:        "slack",
+			kind:        notifierSlack,
+			contentType: "application/json",
+			want:        map[string]interface{}{"text": "[prod] mysql is denied"},
+		},
+		{
+			name:        "teams",
+			kind:        notifierTeams,
+			contentType: "application/json",
+			want: map[string]interface{}{
+				"@type":    "MessageCard",
+				"@context": "https://schema.org/extensions",
+				"summary":  "Tugger",
+				"text":     "[prod] mysql is denied",
+			},
+		},
+		{
+			name:        "webhook default template",
+			kind:        notifierWebhook,
+			contentType: "application/json",
+			want:        map[string]interface{}{"message": "mysql is denied", "env": "prod", "time": "2026-10-18T09:00:00Z"},
+		},
+		{
+			name:        "webhook template",
+			kind:        notifierWebhook,
+			template:    `{"summary":{{ json .Text }},"cluster":"{{ .Env }}"}`,
+			contentType: "application/json",
+			want:        map[string]interface{}{"summary": "[prod] mysql is denied", "cluster": "prod"},
+		},
+		{
+			name:        "cloudevents",
+			kind:        notifierCloudEvents,
+			contentType: "application/cloudevents+json",
+			want: map[string]interface{}{
+				"specversion":     "1.0",
+				"source":          "tugger",
+				"type":            cloudEventType,
+				"time":            "2026-10-18T09:00:00Z",
+				"datacontenttype": "application/json",
+				"data":            map[string]interface{}{"message": "mysql is denied", "env": "prod"},
+			},
+		},
+		{
+			name:     "invalid template",
+			kind:     notifierWebhook,
+			template: `{{ .Message`,
+			wantErr:  true,
+		},
+		{
+			name:    "unknown kind",
+			kind:    "email",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]interface{}
+			var contentType string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				contentType = r.Header.Get("Content-Type")
+				data, _ := ioutil.ReadAll(r.Body)
+				if err := json.Unmarshal(data, &got); err != nil {
+					t.Errorf("body %s is not JSON: %v", data, err)
+				}
+				w.Write([]byte("ok"))
+			}))
+			defer server.Close()
+
+			notifier, err := newNotifier(tt.kind, server.URL, tt.template, "tugger")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newNotifier() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if err := notifier.Notify(n); err != nil {
+				t.Fatal(err)
+			}
+			if contentType != tt.contentType {
+				t.Errorf("Content-Type = %v, want %v", contentType, tt.contentType)
+			}
+			if id, ok := got["id"]; ok && id == "" {
+				t.Errorf("CloudEvent has no id")
+			}
+			delete(got, "id")
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("body = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+// recordingNotifier records the notifications it is asked to send
+type recordingNotifier struct {
+	sent []notification
+}
+
+func (n *recordingNotifier) Name() string { return "recording" }
+
+func (n *recordingNotifier) Notify(msg notification) error {
+	n.sent = append(n.sent, msg)
+	return nil
+}
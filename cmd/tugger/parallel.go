@@ -0,0 +1,35 @@
+package main
+
+import (
+	"sync"
+)
+
+// containerConcurrency bounds how many containers of one admission request are evaluated at once
+var containerConcurrency = 4
+
+// forEachParallel calls fn for every index from 0 to n-1 with at most workers calls running at
+// once, and returns when all calls are done. Callers store results by index to keep their order.
+func forEachParallel(n, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > n {
+		workers = n
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_forEachParallel(t *testing.T) {
+	tests := []struct {
+		name        string
+		n           int
+		workers     int
+		wantRunning int32
+	}{
+		{name: "bounded", n: 10, workers: 3, wantRunning: 3},
+		{name: "fewer items than workers", n: 2, workers: 8, wantRunning: 2},
+		{name: "sequential", n: 5, workers: 0, wantRunning: 1},
+		{name: "empty", n: 0, workers: 4, wantRunning: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var running, maxRunning int32
+			var mu sync.Mutex
+			done := make([]bool, tt.n)
+			forEachParallel(tt.n, tt.workers, func(i int) {
+				r := atomic.AddInt32(&running, 1)
+				mu.Lock()
+				if r > maxRunning {
+					maxRunning = r
+				}
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				done[i] = true
+				atomic.AddInt32(&running, -1)
+			})
+			for i, ok := range done {
+				if !ok {
+					t.Errorf("forEachParallel() skipped index %d", i)
+				}
+			}
+			if maxRunning != tt.wantRunning {
+				t.Errorf("forEachParallel() ran %d calls at once, want %d", maxRunning, tt.wantRunning)
+			}
+		})
+	}
+}
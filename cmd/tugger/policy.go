@@ -3,22 +3,171 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"path"
 	"regexp"
+	"strings"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	yaml "gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/labels"
 )
 
 // Pattern defines one rule in a policy
 type Pattern struct {
 	re          *regexp.Regexp
-	Pattern     string
+	Pattern     string `yaml:",omitempty"`
 	Replacement string `yaml:",omitempty"`
 	Condition   string `yaml:",omitempty"`
+	// Action is what happens to a matching image, see ruleAction
+	Action string `yaml:",omitempty"`
+	// Message is the reason returned to the user when a deny rule rejects an image
+	Message string `yaml:",omitempty"`
+	// Constraints restrict the tags and digests of images an allow rule admits in validation
+	Constraints *Constraints `yaml:",omitempty"`
+
+	// Registry, Repository, Tag and Digest match the components of the parsed image name,
+	// see newComponentMatcher
+	Registry   string `yaml:",omitempty"`
+	Repository string `yaml:",omitempty"`
+	Tag        string `yaml:",omitempty"`
+	Digest     string `yaml:",omitempty"`
+	components []*componentMatcher
+}
+
+// Rule actions for matching images
+const (
+	// actionAllow admits the image unchanged
+	actionAllow = "allow"
+	// actionDeny rejects the image, regardless of any later rule
+	actionDeny = "deny"
+	// actionRewrite replaces the image name in the mutating admission controller
+	actionRewrite = "rewrite"
+)
+
+// action returns the rule's action, which defaults to rewrite for rules with a replacement and
+// to allow otherwise
+func (rule *Pattern) action() string {
+	if rule.Action != "" {
+		return rule.Action
+	}
+	if rule.Replacement != "" {
+		return actionRewrite
+	}
+	return actionAllow
+}
+
+// validateAction checks that the action of a rule is known and consistent with its other fields
+func (rule *Pattern) validateAction() error {
+	switch rule.action() {
+	case actionAllow, actionDeny:
+		if rule.Replacement != "" {
+			return fmt.Errorf("%s rules can not have a replacement", rule.action())
+		}
+	case actionRewrite:
+		if rule.Replacement == "" {
+			return fmt.Errorf("rewrite rules must have a replacement")
+		}
+	default:
+		return fmt.Errorf("action must be allow, deny or rewrite, not %s", rule.Action)
+	}
+	if rule.Message != "" && rule.action() != actionDeny {
+		return fmt.Errorf("only deny rules can have a message")
+	}
+	if rule.Constraints != nil && rule.action() != actionAllow {
+		return fmt.Errorf("only allow rules can have constraints")
+	}
+	return nil
+}
+
+// compile compiles the pattern and component expressions of a rule
+func (rule *Pattern) compile() error {
+	if err := rule.validateAction(); err != nil {
+		return err
+	}
+	rule.components = nil
+	for _, c := range []struct {
+		name  string
+		expr  string
+		value func(imageRef) string
+	}{
+		{"registry", rule.Registry, func(r imageRef) string { return r.Registry }},
+		{"repository", rule.Repository, func(r imageRef) string { return r.Repository }},
+		{"tag", rule.Tag, func(r imageRef) string { return r.Tag }},
+		{"digest", rule.Digest, func(r imageRef) string { return r.Digest }},
+	} {
+		if c.expr == "" {
+			continue
+		}
+		m, err := newComponentMatcher(c.name, c.expr, c.value)
+		if err != nil {
+			return err
+		}
+		rule.components = append(rule.components, m)
+	}
+
+	pattern := rule.Pattern
+	if pattern == "" && len(rule.components) > 0 {
+		// structured rules capture the whole image name for replacements
+		pattern = "^(.*)$"
+	}
+	var err error
+	rule.re, err = regexp.Compile(pattern)
+	return err
 }
 
+// Match reports whether an image matches the rule's pattern and all of its component expressions
+func (rule *Pattern) Match(image string) bool {
+	if !rule.re.MatchString(image) {
+		return false
+	}
+	if len(rule.components) == 0 {
+		return true
+	}
+	ref, err := parseImageRef(image)
+	if err != nil {
+		log.WithError(err).WithField("image", image).Debug("could not parse image")
+		return false
+	}
+	for _, m := range rule.components {
+		if !m.Match(ref) {
+			return false
+		}
+	}
+	return true
+}
+
+// Enforcement modes for images rejected by a policy
+const (
+	// modeEnforce denies the admission request
+	modeEnforce = "enforce"
+	// modeWarn allows the request and returns the denial as a warning to the client
+	modeWarn = "warn"
+	// modeAudit allows the request and only records the denial in audit annotations and logs
+	modeAudit = "audit"
+)
+
 // Policy defines a policy to mutate image names
 type Policy struct {
 	Rules []*Pattern
+	// Mode is the enforcement mode for rejected images, enforce by default
+	Mode string `yaml:",omitempty"`
+	// NamespaceModes overrides Mode for individual namespaces
+	NamespaceModes map[string]string `yaml:"namespaceModes,omitempty"`
+	// Policies are named policy blocks used instead of this policy in the namespaces they select
+	Policies []*Policy `yaml:",omitempty"`
+
+	// Name identifies a policy block
+	Name string `yaml:",omitempty"`
+	// Namespaces selects the namespaces a policy block applies to by name or glob, e.g. prod-*
+	Namespaces []string `yaml:",omitempty"`
+	// NamespaceSelector selects the namespaces a policy block applies to by label selector, e.g. env=prod
+	NamespaceSelector string `yaml:"namespaceSelector,omitempty"`
+
+	hash     string
+	selector labels.Selector
+	keychain authn.Keychain
+	// tracer receives the evaluation of each rule, see WithTracer
+	tracer func(ruleTrace)
 }
 
 // PolicyOption options for NewPolicy()
@@ -29,12 +178,34 @@ func (p *Policy) Load(in []byte) error {
 	if err := yaml.Unmarshal(in, p); err != nil {
 		return err
 	}
-	if len(p.Rules) == 0 {
+	if len(p.Rules) == 0 && len(p.Policies) == 0 {
 		return fmt.Errorf("policy rules must be non-empty slice")
 	}
+	if err := p.compile(); err != nil {
+		return err
+	}
+	for _, block := range p.Policies {
+		if err := block.compileBlock(); err != nil {
+			return fmt.Errorf("policy %s: %w", block.Name, err)
+		}
+	}
+	p.hash = policyHash(in)
+	log.WithField("policy", string(in)).Print("loaded policy")
+	return nil
+}
+
+// compile validates the modes and compiles the rules of a policy
+func (p *Policy) compile() error {
+	if err := validateMode(p.Mode); err != nil {
+		return err
+	}
+	for namespace, mode := range p.NamespaceModes {
+		if err := validateMode(mode); err != nil {
+			return fmt.Errorf("namespace %s: %w", namespace, err)
+		}
+	}
 	for _, rule := range p.Rules {
-		var err error
-		if rule.re, err = regexp.Compile(rule.Pattern); err != nil {
+		if err := rule.compile(); err != nil {
 			return err
 		}
 		switch rule.Condition {
@@ -45,48 +216,340 @@ func (p *Policy) Load(in []byte) error {
 			return fmt.Errorf("condition must be null/Always (default) or Exists, not %s", rule.Condition)
 		}
 	}
-	log.WithField("policy", string(in)).Print("loaded policy")
 	return nil
 }
 
+// compileBlock validates the namespace selection of a policy block and compiles it
+func (p *Policy) compileBlock() error {
+	if p.Name == "" {
+		return fmt.Errorf("policy blocks must have a name")
+	}
+	if len(p.Rules) == 0 {
+		return fmt.Errorf("policy rules must be non-empty slice")
+	}
+	if len(p.Policies) > 0 {
+		return fmt.Errorf("policy blocks can not be nested")
+	}
+	if len(p.Namespaces) == 0 && p.NamespaceSelector == "" {
+		return fmt.Errorf("policy blocks must select namespaces by namespaces or namespaceSelector")
+	}
+	for _, pattern := range p.Namespaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("namespace %s: %w", pattern, err)
+		}
+	}
+	if p.NamespaceSelector != "" {
+		var err error
+		if p.selector, err = labels.Parse(p.NamespaceSelector); err != nil {
+			return err
+		}
+	}
+	return p.compile()
+}
+
+// usesNamespaceSelector reports whether any policy block selects namespaces by label
+func (p *Policy) usesNamespaceSelector() bool {
+	for _, block := range p.Policies {
+		if block.selector != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceMatch ranks how specifically a policy block selects a namespace, higher is more specific
+type namespaceMatch struct {
+	// kind is 3 for a namespace name, 2 for a glob, 1 for a label selector and 0 for no match
+	kind int
+	// weight breaks ties within a kind: the literal length of a glob or the requirements in a selector
+	weight int
+}
+
+func (m namespaceMatch) moreSpecificThan(o namespaceMatch) bool {
+	return m.kind > o.kind || (m.kind == o.kind && m.weight > o.weight)
+}
+
+// matchNamespace returns how specifically the policy block selects the namespace
+func (p *Policy) matchNamespace(namespace string, nsLabels func() labels.Set) namespaceMatch {
+	best := namespaceMatch{}
+	for _, pattern := range p.Namespaces {
+		m := namespaceMatch{}
+		if pattern == namespace {
+			m = namespaceMatch{kind: 3}
+		} else if ok, _ := path.Match(pattern, namespace); ok {
+			m = namespaceMatch{kind: 2, weight: len(pattern) - strings.Count(pattern, "*") - strings.Count(pattern, "?")}
+		}
+		if m.moreSpecificThan(best) {
+			best = m
+		}
+	}
+	if best.kind == 0 && p.selector != nil {
+		if requirements, _ := p.selector.Requirements(); p.selector.Matches(nsLabels()) {
+			best = namespaceMatch{kind: 1, weight: len(requirements)}
+		}
+	}
+	return best
+}
+
+// ForNamespace returns the most specific policy block selecting the namespace, or the policy itself
+// when no block does. Blocks naming the namespace win over globs, and globs over label selectors.
+// Ties go to the block defined first.
+func (p *Policy) ForNamespace(namespace string) *Policy {
+	if len(p.Policies) == 0 {
+		return p
+	}
+
+	var nsLabels labels.Set
+	lookup := func() labels.Set {
+		if nsLabels == nil {
+			nsLabels = namespaceLabels(namespace)
+		}
+		return nsLabels
+	}
+
+	selected, best := p, namespaceMatch{}
+	for _, block := range p.Policies {
+		if m := block.matchNamespace(namespace, lookup); m.moreSpecificThan(best) {
+			selected, best = block, m
+		}
+	}
+	return selected
+}
+
+// WithKeychain returns a copy of the policy that authenticates registry lookups with the keychain
+func (p *Policy) WithKeychain(keychain authn.Keychain) *Policy {
+	c := *p
+	c.keychain = keychain
+	return &c
+}
+
+// WithTracer returns a copy of the policy that reports the evaluation of each rule to tracer.
+// Evaluating an image with a traced policy does not admit it, so rule matches are not counted
+// and no notifications are sent.
+func (p *Policy) WithTracer(tracer func(ruleTrace)) *Policy {
+	c := *p
+	c.tracer = tracer
+	return &c
+}
+
+// ruleTrace is the evaluation of one rule for an image
+type ruleTrace struct {
+	// Phase is mutate or validate
+	Phase string `json:"phase"`
+	// Rule is the position of the rule, starting at 1
+	Rule    int  `json:"rule"`
+	Matched bool `json:"matched"`
+	// Replacement is the image name computed by a rewrite rule
+	Replacement string `json:"replacement,omitempty"`
+	// Exists is the result of the Exists condition: found, missing or the lookup error
+	Exists string `json:"exists,omitempty"`
+	// Violation is the constraint an image violates
+	Violation string `json:"violation,omitempty"`
+	// Decided is set on the rule that decided the image
+	Decided bool `json:"decided"`
+	// Evaluated is unset for rules after the deciding one
+	Evaluated bool `json:"evaluated"`
+	// Skipped explains why a rule is not evaluated in the phase at all
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// countMatch records that the rule at index i decided an image, unless the policy is traced
+func (p *Policy) countMatch(i int, rule *Pattern) {
+	if p.tracer == nil {
+		countRuleMatch(p, i, rule)
+	}
+}
+
+// notify sends a notification, unless the policy is traced
+func (p *Policy) notify(msg string) {
+	if p.tracer == nil {
+		sendNotification(msg)
+	}
+}
+
+// trace reports the evaluation of a rule to the tracer, if any
+func (p *Policy) trace(t ruleTrace) {
+	if p.tracer != nil {
+		p.tracer(t)
+	}
+}
+
+// RuleSet describes the policy for messages, e.g. policy prod
+func (p *Policy) RuleSet() string {
+	if p.Name == "" {
+		return "policy"
+	}
+	return "policy " + p.Name
+}
+
+// Hash returns the hash of the YAML the policy was loaded from
+func (p *Policy) Hash() string {
+	return p.hash
+}
+
+func validateMode(mode string) error {
+	switch mode {
+	case "":
+	case modeEnforce:
+	case modeWarn:
+	case modeAudit:
+	default:
+		return fmt.Errorf("mode must be null/enforce (default), warn or audit, not %s", mode)
+	}
+	return nil
+}
+
+// EnforcementMode returns the enforcement mode for images rejected in a namespace
+func (p *Policy) EnforcementMode(namespace string) string {
+	if mode, ok := p.NamespaceModes[namespace]; ok && mode != "" {
+		return mode
+	}
+	if p.Mode != "" {
+		return p.Mode
+	}
+	return modeEnforce
+}
+
+// decision is the result of evaluating an image against a policy
+type decision struct {
+	// image is the final image name
+	image   string
+	allowed bool
+	// rule is the position of the rule that decided the image, starting at 1, or 0 if none did
+	rule int
+	// reason explains a denial
+	reason string
+	// missing is set when a rewrite was skipped because the image does not exist in the registry
+	missing bool
+	// unreachable is set when the image is denied because its registry is unreachable, with
+	// --registry-unreachable=deny
+	unreachable bool
+}
+
 // MutateImage transforms the image name according to the policy, or returns false if there were no matches
+// or a deny rule matched first
 func (p *Policy) MutateImage(image string) (string, bool) {
+	d := p.mutate(image)
+	return d.image, d.allowed
+}
+
+func (p *Policy) mutate(image string) decision {
 	var msg string
-	for _, rule := range p.Rules {
-		if rule.re.MatchString(image) {
-			image := image
-			if rule.Replacement != "" {
-				image = rule.re.ReplaceAllString(image, rule.Replacement)
+	original := image
+	for i, rule := range p.Rules {
+		t := ruleTrace{Phase: "mutate", Rule: i + 1}
+		if !rule.Match(image) {
+			p.trace(t)
+			continue
+		}
+		t.Matched = true
+		image := image
+		if rule.action() == actionRewrite {
+			image = rule.re.ReplaceAllString(image, rule.Replacement)
+			t.Replacement = image
+		}
+		if rule.Condition == "Exists" {
+			exists, err := imageExists(image, p.keychain)
+			if err != nil {
+				t.Exists, t.Decided = err.Error(), true
+				p.trace(t)
+				log.WithError(err).WithField("image", original).Print("image is denied")
+				p.notify(err.Error())
+				return decision{image: original, rule: i + 1, reason: err.Error(), unreachable: true}
 			}
-			if rule.Condition == "Exists" && !imageExists(image) {
+			if !exists {
+				t.Exists = "missing"
+				p.trace(t)
 				msg = fmt.Sprintf("%s does not exist in private registry", image)
 				log.Debug(msg)
 				continue
 			}
-			return image, true
+			t.Exists = "found"
+		}
+		t.Decided = true
+		p.trace(t)
+		p.countMatch(i, rule)
+		if rule.action() == actionDeny {
+			log.WithField("image", image).Debug("image is denied by policy")
+			return decision{image: image, rule: i + 1, reason: rule.denyReason(i)}
 		}
+		return decision{image: image, allowed: true, rule: i + 1}
 	}
 	if msg != "" {
 		log.Print(msg)
-		SendSlackNotification(msg)
+		p.notify(msg)
+		return decision{image: image, reason: msg, missing: true}
 	}
-	return image, false
+	return decision{image: image, reason: "no rule matched"}
 }
 
 // ValidateImage checks if an image conforms to any of the patterns in a policy without replacement
 func (p *Policy) ValidateImage(image string) bool {
-	for _, rule := range p.Rules {
-		if rule.Replacement != "" {
+	allowed, _ := p.Validate(image)
+	return allowed
+}
+
+// Validate checks an image against the rules without replacement, in order. When a deny rule
+// matches first it returns the rule's message, or the rule's position if it has none. When an allow
+// rule matches first, the image is denied if it violates the rule's constraints.
+func (p *Policy) Validate(image string) (bool, string) {
+	d := p.validate(image)
+	if d.rule == 0 {
+		return d.allowed, ""
+	}
+	return d.allowed, d.reason
+}
+
+func (p *Policy) validate(image string) decision {
+	for i, rule := range p.Rules {
+		if rule.action() == actionRewrite {
 			continue
 		}
-		if rule.Condition == "Exists" && !imageExists(image) {
+		t := ruleTrace{Phase: "validate", Rule: i + 1}
+		if !rule.Match(image) {
+			p.trace(t)
 			continue
 		}
-		if rule.re.MatchString(image) {
-			return true
+		t.Matched = true
+		if rule.Condition == "Exists" {
+			exists, err := imageExists(image, p.keychain)
+			if err != nil {
+				t.Exists, t.Decided = err.Error(), true
+				p.trace(t)
+				return decision{image: image, rule: i + 1, reason: err.Error(), unreachable: true}
+			}
+			if !exists {
+				t.Exists = "missing"
+				p.trace(t)
+				continue
+			}
+			t.Exists = "found"
+		}
+		t.Decided = true
+		p.countMatch(i, rule)
+		if rule.action() == actionDeny {
+			p.trace(t)
+			return decision{image: image, rule: i + 1, reason: rule.denyReason(i)}
+		}
+		if rule.Constraints != nil {
+			if violation := rule.Constraints.Check(image); violation != "" {
+				t.Violation = violation
+				p.trace(t)
+				return decision{image: image, rule: i + 1, reason: violation}
+			}
 		}
+		p.trace(t)
+		return decision{image: image, allowed: true, rule: i + 1}
 	}
-	return false
+	return decision{image: image, reason: "no rule matched"}
+}
+
+// denyReason returns the message of a deny rule at index i, or its position if it has none
+func (rule *Pattern) denyReason(i int) string {
+	if rule.Message != "" {
+		return rule.Message
+	}
+	return fmt.Sprintf("denied by rule %d", i+1)
 }
 
 // NewPolicy creates a Policy
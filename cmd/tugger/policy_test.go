@@ -2,9 +2,12 @@ package main
 
 import (
 	"io/ioutil"
+	"net/http"
 	"os"
 	"reflect"
 	"testing"
+
+	"github.com/jarcoal/httpmock"
 )
 
 var defaultPolicy = `
@@ -30,6 +33,75 @@ rules:
   replacement: jainishshah17/$1
 `
 
+var invalidMode = `
+mode: permissive
+rules:
+- pattern: .*
+`
+
+var invalidNamespaceMode = `
+namespaceModes:
+  dev: permissive
+rules:
+- pattern: .*
+`
+
+var namespacePolicy = `
+rules:
+- pattern: .*
+policies:
+- name: prod
+  namespaces: [prod]
+  rules:
+  - pattern: ^jainishshah17/.*@sha256:.*
+- name: prod-glob
+  namespaces: [prod-*]
+  rules:
+  - pattern: ^jainishshah17/.*
+- name: prod-eu-glob
+  namespaces: [prod-eu-*]
+  rules:
+  - pattern: ^eu.jainishshah17/.*
+- name: labelled
+  namespaceSelector: env=dev
+  mode: warn
+  rules:
+  - pattern: ^mirror/.*
+`
+
+var structuredPolicy = `
+rules:
+- registry: docker.io
+  repository: library/nginx
+  tag: "!latest"
+  condition: Always
+- registry: docker\.artifactory\.com
+  digest: sha256:.*
+  condition: Always
+- pattern: ^quay.io/
+  repository: jainishshah17/.*
+  condition: Always
+`
+
+var badComponent = `
+rules:
+- registry: docker.io
+  tag: (
+`
+
+var denyPolicy = `
+rules:
+- pattern: ^jainishshah17/nginx:latest$
+  action: deny
+  message: pin a version of nginx
+- tag: latest
+  action: deny
+- pattern: ^jainishshah17/.*
+- pattern: (.*)
+  action: rewrite
+  replacement: jainishshah17/$1
+`
+
 var badRegex = `
 rules:
 - pattern: ^jainishsha$(.*
@@ -69,6 +141,189 @@ func TestPolicy_Load(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "invalid mode",
+			args: args{
+				in: []byte(invalidMode),
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid namespace mode",
+			args: args{
+				in: []byte(invalidNamespaceMode),
+			},
+			wantErr: true,
+		},
+		{
+			name: "namespace policies",
+			args: args{
+				in: []byte(namespacePolicy),
+			},
+			wantErr: false,
+		},
+		{
+			name: "structured rules",
+			args: args{
+				in: []byte(structuredPolicy),
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid component",
+			args: args{
+				in: []byte(badComponent),
+			},
+			wantErr: true,
+		},
+		{
+			name: "deny rules",
+			args: args{
+				in: []byte(denyPolicy),
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid action",
+			args: args{
+				in: []byte("rules:\n- pattern: .*\n  action: block\n"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "deny with replacement",
+			args: args{
+				in: []byte("rules:\n- pattern: (.*)\n  action: deny\n  replacement: x/$1\n"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "rewrite without replacement",
+			args: args{
+				in: []byte("rules:\n- pattern: .*\n  action: rewrite\n"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "allow with message",
+			args: args{
+				in: []byte("rules:\n- pattern: .*\n  message: allowed\n"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "constraints",
+			args: args{
+				in: []byte("rules:\n- pattern: .*\n  constraints:\n    requireDigest: true\n"),
+			},
+			wantErr: false,
+		},
+		{
+			name: "deny with constraints",
+			args: args{
+				in: []byte("rules:\n- pattern: .*\n  action: deny\n  constraints:\n    requireDigest: true\n"),
+			},
+			wantErr: true,
+		},
+		{
+			name: "namespace policies only",
+			args: args{
+				in: []byte(`
+policies:
+- name: prod
+  namespaces: [prod]
+  rules:
+  - pattern: .*
+`),
+			},
+			wantErr: false,
+		},
+		{
+			name: "unnamed namespace policy",
+			args: args{
+				in: []byte(`
+rules:
+- pattern: .*
+policies:
+- namespaces: [prod]
+  rules:
+  - pattern: .*
+`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "namespace policy without selector",
+			args: args{
+				in: []byte(`
+rules:
+- pattern: .*
+policies:
+- name: prod
+  rules:
+  - pattern: .*
+`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "namespace policy without rules",
+			args: args{
+				in: []byte(`
+rules:
+- pattern: .*
+policies:
+- name: prod
+  namespaces: [prod]
+`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "namespace policy bad glob",
+			args: args{
+				in: []byte(`
+rules:
+- pattern: .*
+policies:
+- name: prod
+  namespaces: ["prod-["]
+  rules:
+  - pattern: .*
+`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "namespace policy bad selector",
+			args: args{
+				in: []byte(`
+rules:
+- pattern: .*
+policies:
+- name: prod
+  namespaceSelector: "env in prod"
+  rules:
+  - pattern: .*
+`),
+			},
+			wantErr: true,
+		},
+		{
+			name: "namespace policy bad regex",
+			args: args{
+				in: []byte(`
+rules:
+- pattern: .*
+policies:
+- name: prod
+  namespaces: [prod]
+  rules:
+  - pattern: ^jainishsha$(.*
+`),
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid regex",
 			args: args{
@@ -194,6 +449,235 @@ func TestPolicy_ValidateImage(t *testing.T) {
 	}
 }
 
+func TestPolicy_ValidateImageStructured(t *testing.T) {
+	digest := "sha256:2d4f6d8aec48cf4e5b9a5e9d4e8f5d5c8a4b8f3d3c1e0f4e1a5a8f6b7c9d0e1f"
+	tests := []struct {
+		name  string
+		image string
+		want  bool
+	}{
+		{name: "short name with tag", image: "nginx:1.19", want: true},
+		{name: "fully qualified with tag", image: "docker.io/library/nginx:1.19", want: true},
+		{name: "implicit latest", image: "nginx", want: false},
+		{name: "explicit latest", image: "docker.io/library/nginx:latest", want: false},
+		{name: "other repository", image: "redis:6", want: false},
+		{name: "digest", image: "docker.artifactory.com/nginx@" + digest, want: true},
+		{name: "tag without digest", image: "docker.artifactory.com/nginx:1.19", want: false},
+		{name: "pattern and repository", image: "quay.io/jainishshah17/nginx:1.0", want: true},
+		{name: "pattern without repository", image: "quay.io/evil/nginx:1.0", want: false},
+		{name: "unparseable", image: "Nginx:%", want: false},
+	}
+	p, err := NewPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Load([]byte(structuredPolicy)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.ValidateImage(tt.image); got != tt.want {
+				t.Errorf("Policy.ValidateImage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name       string
+		image      string
+		want       bool
+		wantReason string
+	}{
+		{name: "deny message", image: "jainishshah17/nginx:latest", want: false, wantReason: "pin a version of nginx"},
+		{name: "deny without message", image: "jainishshah17/redis", want: false, wantReason: "denied by rule 2"},
+		{name: "allow", image: "jainishshah17/nginx:1.19", want: true},
+		{name: "no match", image: "nginx:1.19", want: false},
+	}
+	p, err := NewPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Load([]byte(denyPolicy)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason := p.Validate(tt.image)
+			if got != tt.want {
+				t.Errorf("Policy.Validate() = %v, want %v", got, tt.want)
+			}
+			if reason != tt.wantReason {
+				t.Errorf("Policy.Validate() reason = %v, want %v", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestPolicy_MutateImageDeny(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		want    string
+		allowed bool
+	}{
+		{name: "deny", image: "jainishshah17/nginx:latest", want: "jainishshah17/nginx:latest", allowed: false},
+		{name: "deny before rewrite", image: "nginx", want: "nginx", allowed: false},
+		{name: "rewrite", image: "nginx:1.19", want: "jainishshah17/nginx:1.19", allowed: true},
+	}
+	p, err := NewPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Load([]byte(denyPolicy)); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, allowed := p.MutateImage(tt.image)
+			if got != tt.want {
+				t.Errorf("Policy.MutateImage() got = %v, want %v", got, tt.want)
+			}
+			if allowed != tt.allowed {
+				t.Errorf("Policy.MutateImage() allowed = %v, want %v", allowed, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestPolicy_ValidateUnreachable(t *testing.T) {
+	registryUnreachable = unreachableDeny
+	defer func() {
+		registryUnreachable = unreachableMissing
+	}()
+	defer runMockRegistry()()
+	httpmock.RegisterResponder("HEAD", "https://index.docker.io/v2/jainishshah17/nginx/manifests/unavailable",
+		httpmock.NewStringResponder(http.StatusServiceUnavailable, ``))
+
+	p, err := NewPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Load([]byte(defaultPolicy)); err != nil {
+		t.Fatal(err)
+	}
+	allowed, reason := p.Validate("jainishshah17/nginx:unavailable")
+	if allowed {
+		t.Error("Policy.Validate() = true, want false")
+	}
+	if want := "could not verify that jainishshah17/nginx:unavailable exists, its registry is unreachable"; reason != want {
+		t.Errorf("Policy.Validate() reason = %v, want %v", reason, want)
+	}
+	if got, allowed := p.MutateImage("jainishshah17/nginx:unavailable"); got != "jainishshah17/nginx:unavailable" || allowed {
+		t.Errorf("Policy.MutateImage() = %v, %v, want unchanged and not allowed", got, allowed)
+	}
+}
+
+func TestPolicy_EnforcementMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		in        string
+		namespace string
+		want      string
+	}{
+		{
+			name:      "default",
+			in:        defaultPolicy,
+			namespace: "foobar",
+			want:      modeEnforce,
+		},
+		{
+			name: "policy mode",
+			in: `
+mode: audit
+rules:
+- pattern: .*
+`,
+			namespace: "foobar",
+			want:      modeAudit,
+		},
+		{
+			name: "namespace mode",
+			in: `
+mode: audit
+namespaceModes:
+  foobar: warn
+rules:
+- pattern: .*
+`,
+			namespace: "foobar",
+			want:      modeWarn,
+		},
+		{
+			name: "other namespace",
+			in: `
+namespaceModes:
+  foobar: warn
+rules:
+- pattern: .*
+`,
+			namespace: "prod",
+			want:      modeEnforce,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPolicy()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := p.Load([]byte(tt.in)); err != nil {
+				t.Fatal(err)
+			}
+			if got := p.EnforcementMode(tt.namespace); got != tt.want {
+				t.Errorf("Policy.EnforcementMode() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_ForNamespace(t *testing.T) {
+	setNamespaceLister(newFakeNamespaceLister(t, map[string]map[string]string{
+		"dev-1":     {"env": "dev"},
+		"prod-tool": {"env": "dev"},
+	}))
+	defer setNamespaceLister(nil)
+
+	p, err := NewPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Load([]byte(namespacePolicy)); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		namespace string
+		want      string
+	}{
+		{namespace: "prod", want: "policy prod"},
+		{namespace: "prod-us-1", want: "policy prod-glob"},
+		{namespace: "prod-eu-1", want: "policy prod-eu-glob"},
+		{namespace: "prod-tool", want: "policy prod-glob"},
+		{namespace: "dev-1", want: "policy labelled"},
+		{namespace: "other", want: "policy"},
+		{namespace: "unknown", want: "policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.namespace, func(t *testing.T) {
+			if got := p.ForNamespace(tt.namespace).RuleSet(); got != tt.want {
+				t.Errorf("Policy.ForNamespace() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if got := p.ForNamespace("dev-1").EnforcementMode("dev-1"); got != modeWarn {
+		t.Errorf("Policy.ForNamespace().EnforcementMode() = %v, want %v", got, modeWarn)
+	}
+	if !p.usesNamespaceSelector() {
+		t.Errorf("Policy.usesNamespaceSelector() = false, want true")
+	}
+}
+
 func TestNewPolicy(t *testing.T) {
 	tmpfile, err := ioutil.TempFile("", "policy*.yaml")
 	if err != nil {
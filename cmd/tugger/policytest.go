@@ -0,0 +1,307 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/infobloxopen/atlas-app-toolkit/logging"
+	yaml "gopkg.in/yaml.v2"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Expected outcomes of a policy test
+const (
+	expectAllowed   = "allowed"
+	expectDenied    = "denied"
+	expectRewritten = "rewritten"
+)
+
+// policyTestFile lists images and what a policy is expected to do with them
+type policyTestFile struct {
+	// Registry stubs the registry lookups of the Exists condition
+	Registry registryStub `yaml:",omitempty"`
+	// Namespaces are the labels of namespaces, for policy blocks with a namespaceSelector
+	Namespaces map[string]map[string]string `yaml:",omitempty"`
+	Tests      []policyTest
+}
+
+// registryStub answers registry lookups from lists of images, an image in neither list is missing.
+// Entries may be globs such as jainishshah17/*.
+type registryStub struct {
+	Exists      []string `yaml:",omitempty"`
+	Unreachable []string `yaml:",omitempty"`
+	// UnreachablePolicy is --registry-unreachable for the tests: missing, exists or deny
+	UnreachablePolicy string `yaml:"unreachablePolicy,omitempty"`
+}
+
+// policyTest is an image and the expected outcome of admitting it
+type policyTest struct {
+	Name      string `yaml:",omitempty"`
+	Image     string
+	Namespace string `yaml:",omitempty"`
+	// Expect is allowed, denied or rewritten, rewritten is implied by RewrittenTo
+	Expect      string `yaml:",omitempty"`
+	RewrittenTo string `yaml:"rewrittenTo,omitempty"`
+	// Rule is the expected position of the deciding rule in its rule set, starting at 1
+	Rule int `yaml:",omitempty"`
+	// Reason is expected to be part of the denial reason
+	Reason string `yaml:",omitempty"`
+}
+
+// imageOutcome is what the mutating and then the validating admission controller do with an image
+type imageOutcome struct {
+	Expect  string `json:"outcome"`
+	Image   string `json:"image"`
+	RuleSet string `json:"ruleSet"`
+	Rule    int    `json:"rule,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// String describes the outcome, e.g. rewritten to jainishshah17/nginx by rule 2 of policy
+func (o imageOutcome) String() string {
+	s := o.Expect
+	if o.Expect == expectRewritten {
+		s += " to " + o.Image
+	}
+	if o.Rule != 0 {
+		s += fmt.Sprintf(" by rule %d of %s", o.Rule, o.RuleSet)
+	} else {
+		s += fmt.Sprintf(", no rule of %s matched", o.RuleSet)
+	}
+	if o.Reason != "" && o.Expect == expectDenied {
+		s += ": " + o.Reason
+	}
+	return s
+}
+
+// evaluateImage admits an image in a namespace like the webhooks do: the mutating admission
+// controller rewrites it, and the validating admission controller checks the result. Rule matches
+// are not counted and no notifications are sent.
+func evaluateImage(p *Policy, image, namespace string) imageOutcome {
+	p = p.ForNamespace(namespace)
+	if p.tracer == nil {
+		p = p.WithTracer(func(ruleTrace) {})
+	}
+	outcome := imageOutcome{Image: image, RuleSet: p.RuleSet()}
+
+	mutated := p.mutate(image)
+	if mutated.allowed {
+		outcome.Image = mutated.image
+	}
+	validated := p.validate(outcome.Image)
+	switch {
+	case !validated.allowed:
+		outcome.Expect, outcome.Rule, outcome.Reason = expectDenied, validated.rule, validated.reason
+		switch {
+		case !mutated.allowed && mutated.rule != 0:
+			outcome.Rule, outcome.Reason = mutated.rule, mutated.reason
+		case mutated.missing:
+			// No rule matched because the rewritten image does not exist
+			outcome.Reason = mutated.reason
+		case validated.rule == 0:
+			outcome.Reason = ""
+		}
+	case outcome.Image != image:
+		outcome.Expect, outcome.Rule = expectRewritten, mutated.rule
+	default:
+		outcome.Expect, outcome.Rule = expectAllowed, validated.rule
+	}
+	return outcome
+}
+
+// check returns why the outcome does not meet the expectations of the test, or an empty string
+func (t policyTest) check(o imageOutcome) string {
+	expect := t.Expect
+	if expect == "" && t.RewrittenTo != "" {
+		expect = expectRewritten
+	}
+	switch {
+	case o.Expect != expect:
+	case t.RewrittenTo != "" && o.Image != t.RewrittenTo:
+	case t.Rule != 0 && o.Rule != t.Rule:
+	case t.Reason != "" && !strings.Contains(o.Reason, t.Reason):
+	default:
+		return ""
+	}
+	want := expect
+	if t.RewrittenTo != "" {
+		want += " to " + t.RewrittenTo
+	}
+	if t.Rule != 0 {
+		want += fmt.Sprintf(" by rule %d", t.Rule)
+	}
+	if t.Reason != "" {
+		want += fmt.Sprintf(" with reason %q", t.Reason)
+	}
+	return fmt.Sprintf("expected %s, got %s", want, o)
+}
+
+// validate checks that the test can be evaluated
+func (t policyTest) validate() error {
+	if t.Image == "" {
+		return fmt.Errorf("test has no image")
+	}
+	switch t.Expect {
+	case expectAllowed, expectDenied:
+		if t.RewrittenTo != "" {
+			return fmt.Errorf("rewrittenTo is only allowed when expecting rewritten, not %s", t.Expect)
+		}
+	case expectRewritten:
+	case "":
+		if t.RewrittenTo == "" {
+			return fmt.Errorf("expect must be %s, %s or %s", expectAllowed, expectDenied, expectRewritten)
+		}
+	default:
+		return fmt.Errorf("expect must be %s, %s or %s, not %s", expectAllowed, expectDenied, expectRewritten, t.Expect)
+	}
+	return nil
+}
+
+// label identifies the test in the report
+func (t policyTest) label() string {
+	label := t.Image
+	if t.Name != "" {
+		label = t.Name + ": " + label
+	}
+	if t.Namespace != "" {
+		label += " in namespace " + t.Namespace
+	}
+	return label
+}
+
+// lookup answers a registry lookup from the stub
+func (s registryStub) lookup(image string, keychain authn.Keychain) imageStatus {
+	match := func(patterns []string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, image); ok || pattern == image {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case match(s.Exists):
+		return imageFound
+	case match(s.Unreachable):
+		return imageUnreachable
+	}
+	return imageMissing
+}
+
+// namespaceStub returns a lister serving namespaces with the given labels
+func namespaceStub(namespaces map[string]map[string]string) (corev1listers.NamespaceLister, error) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	for name, nsLabels := range namespaces {
+		if err := indexer.Add(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nsLabels}}); err != nil {
+			return nil, err
+		}
+	}
+	return corev1listers.NewNamespaceLister(indexer), nil
+}
+
+// runPolicyTest implements tugger test: the images of the test files in args are evaluated
+// against the policy file, and each mismatch is reported. It returns the exit code.
+func runPolicyTest(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: tugger test --policy-file FILE [flags] TEST_FILE...")
+		fmt.Fprintln(stderr, "Checks that a policy allows, denies or rewrites images as the test files expect.")
+		fmt.Fprintln(stderr, "Exits 1 when a test fails and 2 when the policy or a test file can not be read.")
+		flags.PrintDefaults()
+	}
+	policyFile := flags.String("policy-file", "", "YAML file defining allowed image name patterns (see readme)")
+	verbose := flags.Bool("v", false, "also report passing tests")
+	logLevel := flags.String("log-level", "error", "log verbosity, logs are written to stderr")
+	if err := flags.Parse(args); err != nil {
+		return exitError
+	}
+	if *policyFile == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return exitError
+	}
+
+	log = logging.New(*logLevel)
+	log.SetOutput(stderr)
+
+	p, err := NewPolicy(WithConfigFile(*policyFile))
+	if err != nil {
+		fmt.Fprintf(stderr, "failed to load policy file %s: %v\n", *policyFile, err)
+		return exitError
+	}
+
+	defaultLookup, defaultUnreachable := lookupImage, registryUnreachable
+	defer func() {
+		lookupImage, registryUnreachable = defaultLookup, defaultUnreachable
+		setNamespaceLister(nil)
+	}()
+
+	passed, failed := 0, 0
+	for _, file := range flags.Args() {
+		tests, err := loadPolicyTests(file)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", file, err)
+			return exitError
+		}
+		lookupImage = tests.Registry.lookup
+		registryUnreachable = defaultUnreachable
+		if tests.Registry.UnreachablePolicy != "" {
+			registryUnreachable = tests.Registry.UnreachablePolicy
+		}
+		lister, err := namespaceStub(tests.Namespaces)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", file, err)
+			return exitError
+		}
+		setNamespaceLister(lister)
+
+		for _, test := range tests.Tests {
+			outcome := evaluateImage(p, test.Image, test.Namespace)
+			if mismatch := test.check(outcome); mismatch != "" {
+				failed++
+				fmt.Fprintf(stdout, "FAIL %s: %s: %s\n", file, test.label(), mismatch)
+				continue
+			}
+			passed++
+			if *verbose {
+				fmt.Fprintf(stdout, "PASS %s: %s: %s\n", file, test.label(), outcome)
+			}
+		}
+	}
+	fmt.Fprintf(stdout, "%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// loadPolicyTests reads and validates a test file
+func loadPolicyTests(file string) (*policyTestFile, error) {
+	in, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	tests := &policyTestFile{}
+	if err := yaml.UnmarshalStrict(in, tests); err != nil {
+		return nil, err
+	}
+	if tests.Registry.UnreachablePolicy != "" {
+		if err := validateRegistryUnreachable(tests.Registry.UnreachablePolicy); err != nil {
+			return nil, err
+		}
+	}
+	for i, test := range tests.Tests {
+		if err := test.validate(); err != nil {
+			return nil, fmt.Errorf("test %d (%s): %w", i+1, test.label(), err)
+		}
+	}
+	return tests, nil
+}
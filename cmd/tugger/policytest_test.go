@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const policyTestPolicy = `
+rules:
+- pattern: ^mysql$
+  action: deny
+  message: use the private mysql image
+- pattern: ^jainishshah17/.*
+- pattern: (.*)
+  replacement: jainishshah17/$1
+  condition: Exists
+policies:
+- name: dev
+  namespaceSelector: env=dev
+  rules:
+  - pattern: .*
+`
+
+func TestRunPolicyTest(t *testing.T) {
+	dir := writeCheckFiles(t, map[string]string{
+		"policy.yaml": policyTestPolicy,
+		"pass.yaml": `
+registry:
+  exists:
+  - jainishshah17/nginx
+  - jainishshah17/redis:*
+  unreachable:
+  - jainishshah17/flaky
+namespaces:
+  playground:
+    env: dev
+tests:
+- image: nginx
+  rewrittenTo: jainishshah17/nginx
+- image: redis:7
+  expect: rewritten
+  rule: 3
+- image: mysql
+  expect: denied
+  rule: 1
+  reason: private mysql
+- image: postgres
+  expect: denied
+  reason: does not exist
+- image: flaky
+  expect: denied
+- image: jainishshah17/app
+  expect: allowed
+  rule: 2
+- name: dev allows anything
+  image: postgres
+  namespace: playground
+  expect: allowed
+`,
+		"unreachable.yaml": `
+registry:
+  unreachable:
+  - jainishshah17/*
+  unreachablePolicy: exists
+tests:
+- image: postgres
+  rewrittenTo: jainishshah17/postgres
+`,
+		"fail.yaml": `
+registry:
+  exists:
+  - jainishshah17/nginx
+tests:
+- name: wrong outcome
+  image: mysql
+  expect: allowed
+- name: wrong rewrite
+  image: nginx
+  rewrittenTo: mirror/nginx
+- name: wrong rule
+  image: jainishshah17/app
+  expect: allowed
+  rule: 1
+- name: wrong reason
+  image: mysql
+  expect: denied
+  reason: compromised
+`,
+		"invalid-expect.yaml": `
+tests:
+- image: nginx
+  expect: rewrote
+`,
+		"unknown-field.yaml": `
+tests:
+- image: nginx
+  expected: allowed
+`,
+		"rewritten-allowed.yaml": `
+tests:
+- image: nginx
+  expect: allowed
+  rewrittenTo: jainishshah17/nginx
+`,
+	})
+	policyFile := filepath.Join(dir, "policy.yaml")
+	defaultLog := log
+	defer func() {
+		log = defaultLog
+	}()
+
+	tests := []struct {
+		name     string
+		args     []string
+		want     []string
+		wantCode int
+	}{
+		{
+			name:     "pass",
+			args:     []string{"--policy-file", policyFile, filepath.Join(dir, "pass.yaml"), filepath.Join(dir, "unreachable.yaml")},
+			want:     []string{"8 passed, 0 failed"},
+			wantCode: exitOK,
+		},
+		{
+			name: "verbose",
+			args: []string{"--policy-file", policyFile, "-v", filepath.Join(dir, "pass.yaml")},
+			want: []string{
+				"PASS " + filepath.Join(dir, "pass.yaml") + ": nginx: rewritten to jainishshah17/nginx by rule 3 of policy",
+				"dev allows anything: postgres in namespace playground: allowed by rule 1 of policy dev",
+			},
+			wantCode: exitOK,
+		},
+		{
+			name: "fail",
+			args: []string{"--policy-file", policyFile, filepath.Join(dir, "fail.yaml")},
+			want: []string{
+				"wrong outcome: mysql: expected allowed, got denied by rule 1 of policy: use the private mysql image",
+				"wrong rewrite: nginx: expected rewritten to mirror/nginx, got rewritten to jainishshah17/nginx by rule 3 of policy",
+				"wrong rule: jainishshah17/app: expected allowed by rule 1, got allowed by rule 2 of policy",
+				`wrong reason: mysql: expected denied with reason "compromised", got denied by rule 1 of policy`,
+				"0 passed, 4 failed",
+			},
+			wantCode: exitFailure,
+		},
+		{
+			name:     "invalid expect",
+			args:     []string{"--policy-file", policyFile, filepath.Join(dir, "invalid-expect.yaml")},
+			wantCode: exitError,
+		},
+		{
+			name:     "unknown field",
+			args:     []string{"--policy-file", policyFile, filepath.Join(dir, "unknown-field.yaml")},
+			wantCode: exitError,
+		},
+		{
+			name:     "rewritten but allowed",
+			args:     []string{"--policy-file", policyFile, filepath.Join(dir, "rewritten-allowed.yaml")},
+			wantCode: exitError,
+		},
+		{
+			name:     "missing test file",
+			args:     []string{"--policy-file", policyFile, filepath.Join(dir, "missing.yaml")},
+			wantCode: exitError,
+		},
+		{
+			name:     "no policy file",
+			args:     []string{filepath.Join(dir, "pass.yaml")},
+			wantCode: exitError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := runPolicyTest(tt.args, &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Errorf("runPolicyTest() = %v, want %v, stdout: %s, stderr: %s", code, tt.wantCode, stdout.String(), stderr.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("output does not contain %q:\n%s", want, stdout.String())
+				}
+			}
+		})
+	}
+	if lookupImage == nil || getNamespaceLister() != nil {
+		t.Error("runPolicyTest() did not restore the registry lookup and namespace lister")
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/patrickmn/go-cache"
+	"golang.org/x/sync/singleflight"
+)
+
+// What an unreachable registry means for the Exists condition
+const (
+	// unreachableMissing treats the image as missing, like a 404
+	unreachableMissing = "missing"
+	// unreachableExists treats the image as existing
+	unreachableExists = "exists"
+	// unreachableDeny denies the image
+	unreachableDeny = "deny"
+)
+
+var (
+	// registryTransport is used for every registry request, tests replace it with a mock
+	registryTransport http.RoundTripper = remote.DefaultTransport
+	// registryTimeout is the deadline for a single registry lookup, 0 disables it
+	registryTimeout = 5 * time.Second
+	// registryUnreachable is what an unreachable registry means for the Exists condition
+	registryUnreachable = unreachableMissing
+	// existsCache caches the Exists lookups, nil disables caching
+	existsCache *imageCache
+)
+
+// validateRegistryUnreachable checks the value of --registry-unreachable
+func validateRegistryUnreachable(value string) error {
+	switch value {
+	case unreachableMissing, unreachableExists, unreachableDeny:
+		return nil
+	}
+	return fmt.Errorf("registry unreachable must be missing, exists or deny, not %s", value)
+}
+
+// registryOptions returns the options for registry requests, authenticated with the keychain
+// before the default keychain
+func registryOptions(ctx context.Context, keychain authn.Keychain) []remote.Option {
+	if keychain == nil {
+		keychain = authn.DefaultKeychain
+	} else {
+		keychain = authn.NewMultiKeychain(keychain, authn.DefaultKeychain)
+	}
+	return []remote.Option{
+		remote.WithAuthFromKeychain(keychain),
+		remote.WithTransport(registryTransport),
+		remote.WithContext(ctx),
+	}
+}
+
+// registryContext returns the context for a single registry lookup
+func registryContext() (context.Context, context.CancelFunc) {
+	if registryTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), registryTimeout)
+}
+
+// imageStatus is the result of looking up an image in its registry
+type imageStatus int
+
+const (
+	// imageMissing means the registry answered that the image does not exist
+	imageMissing imageStatus = iota
+	// imageFound means the image exists
+	imageFound
+	// imageUnreachable means the registry could not be asked, e.g. it timed out or failed
+	imageUnreachable
+)
+
+// imageExists verifies an image exists in the remote registry, authenticating with the keychain
+// if it isn't nil. An unreachable registry is resolved according to registryUnreachable, and
+// yields an error when the image is to be denied.
+func imageExists(image string, keychain authn.Keychain) (bool, error) {
+	lookup := func(image string) imageStatus {
+		return lookupImage(image, keychain)
+	}
+	var status imageStatus
+	if existsCache != nil {
+		key := image
+		if k, ok := keychain.(*pullSecretKeychain); ok {
+			key += " " + k.id()
+		}
+		status = existsCache.Lookup(key, func(string) imageStatus {
+			return lookup(image)
+		})
+	} else {
+		status = lookup(image)
+	}
+	if status != imageUnreachable {
+		return status == imageFound, nil
+	}
+	switch registryUnreachable {
+	case unreachableExists:
+		return true, nil
+	case unreachableDeny:
+		return false, fmt.Errorf("could not verify that %s exists, its registry is unreachable", image)
+	}
+	return false, nil
+}
+
+// lookupImage looks up images for imageExists, tugger test replaces it with a stub
+var lookupImage = headImage
+
+// headImage looks up an image in the remote registry with a HEAD request for its manifest
+func headImage(image string, keychain authn.Keychain) imageStatus {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		log.WithError(err).WithField("image", image).Error("could not parse image")
+		return imageMissing
+	}
+
+	ctx, cancel := registryContext()
+	defer cancel()
+	start := time.Now()
+	if _, err := remote.Head(ref, registryOptions(ctx, keychain)...); err != nil {
+		if isUnreachable(err) {
+			log.WithError(err).WithField("image", image).Error("registry is unreachable")
+			observeRegistryLookup("exists", imageUnreachable.String(), start)
+			return imageUnreachable
+		}
+		log.WithError(err).WithField("image", image).Error("could not fetch image")
+		observeRegistryLookup("exists", imageMissing.String(), start)
+		return imageMissing
+	}
+
+	observeRegistryLookup("exists", imageFound.String(), start)
+	return imageFound
+}
+
+// isUnreachable reports whether a registry error is not a definitive answer: network errors,
+// timeouts, rate limits and server errors, as opposed to e.g. a 404 for an unknown manifest
+func isUnreachable(err error) bool {
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return terr.StatusCode >= http.StatusInternalServerError || terr.StatusCode == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// imageCache caches image lookups, with separate TTLs for images that exist and images that
+// don't or could not be looked up, and runs a single lookup for concurrent requests of the same image
+type imageCache struct {
+	positiveTTL time.Duration
+	negativeTTL time.Duration
+	size        int
+	cache       *cache.Cache
+	group       singleflight.Group
+}
+
+// newImageCache creates a cache holding up to size images
+func newImageCache(positiveTTL, negativeTTL time.Duration, size int) *imageCache {
+	return &imageCache{
+		positiveTTL: positiveTTL,
+		negativeTTL: negativeTTL,
+		size:        size,
+		cache:       cache.New(positiveTTL, time.Minute),
+	}
+}
+
+// Lookup returns the status of the image, calling lookup when it is not cached. The image may
+// be qualified with the credentials used to look it up.
+func (c *imageCache) Lookup(image string, lookup func(string) imageStatus) imageStatus {
+	if status, ok := c.cache.Get(image); ok {
+		log.WithField("image", image).Debug("image lookup cache hit")
+		cacheRequests.WithLabelValues("exists", "hit").Inc()
+		return status.(imageStatus)
+	}
+	cacheRequests.WithLabelValues("exists", "miss").Inc()
+	status, _, _ := c.group.Do(image, func() (interface{}, error) {
+		status := lookup(image)
+		c.add(image, status)
+		return status, nil
+	})
+	return status.(imageStatus)
+}
+
+func (c *imageCache) add(image string, status imageStatus) {
+	ttl := c.positiveTTL
+	if status != imageFound {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 || c.size <= 0 {
+		return
+	}
+	if c.cache.ItemCount() >= c.size {
+		c.cache.DeleteExpired()
+	}
+	if c.cache.ItemCount() >= c.size {
+		c.evict()
+	}
+	c.cache.Set(image, status, ttl)
+}
+
+// evict removes the image closest to expiring
+func (c *imageCache) evict() {
+	var oldest string
+	var expiration int64
+	for image, item := range c.cache.Items() {
+		if oldest == "" || item.Expiration < expiration {
+			oldest, expiration = image, item.Expiration
+		}
+	}
+	c.cache.Delete(oldest)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+)
+
+func Test_imageCache_Lookup(t *testing.T) {
+	tests := []struct {
+		name        string
+		positiveTTL time.Duration
+		negativeTTL time.Duration
+		size        int
+		images      []string
+		wantLookups int32
+	}{
+		{
+			name:        "positive cached",
+			positiveTTL: time.Minute,
+			negativeTTL: time.Minute,
+			size:        10,
+			images:      []string{"nginx", "nginx", "nginx"},
+			wantLookups: 1,
+		},
+		{
+			name:        "negative cached",
+			positiveTTL: time.Minute,
+			negativeTTL: time.Minute,
+			size:        10,
+			images:      []string{"notexist", "notexist"},
+			wantLookups: 1,
+		},
+		{
+			name:        "negative not cached",
+			positiveTTL: time.Minute,
+			negativeTTL: 0,
+			size:        10,
+			images:      []string{"notexist", "notexist"},
+			wantLookups: 2,
+		},
+		{
+			name:        "bounded",
+			positiveTTL: time.Minute,
+			negativeTTL: time.Minute,
+			size:        1,
+			images:      []string{"nginx", "busybox", "nginx"},
+			wantLookups: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lookups int32
+			lookup := func(image string) imageStatus {
+				atomic.AddInt32(&lookups, 1)
+				if image == "notexist" {
+					return imageMissing
+				}
+				return imageFound
+			}
+			c := newImageCache(tt.positiveTTL, tt.negativeTTL, tt.size)
+			for _, image := range tt.images {
+				if got, want := c.Lookup(image, lookup) == imageFound, image != "notexist"; got != want {
+					t.Errorf("imageCache.Lookup(%s) found = %v, want %v", image, got, want)
+				}
+			}
+			if lookups != tt.wantLookups {
+				t.Errorf("imageCache.Lookup() looked up %d images, want %d", lookups, tt.wantLookups)
+			}
+			if count := c.cache.ItemCount(); count > tt.size {
+				t.Errorf("imageCache holds %d images, want at most %d", count, tt.size)
+			}
+		})
+	}
+}
+
+func Test_imageCache_LookupExpires(t *testing.T) {
+	var lookups int32
+	lookup := func(image string) imageStatus {
+		atomic.AddInt32(&lookups, 1)
+		return imageMissing
+	}
+	c := newImageCache(time.Minute, 10*time.Millisecond, 10)
+	c.Lookup("notexist", lookup)
+	time.Sleep(20 * time.Millisecond)
+	c.Lookup("notexist", lookup)
+	if lookups != 2 {
+		t.Errorf("imageCache.Lookup() looked up %d images, want 2", lookups)
+	}
+}
+
+func Test_imageCache_LookupSingleFlight(t *testing.T) {
+	var lookups int32
+	release := make(chan struct{})
+	lookup := func(image string) imageStatus {
+		atomic.AddInt32(&lookups, 1)
+		<-release
+		return imageFound
+	}
+	c := newImageCache(time.Minute, time.Minute, 10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Lookup("nginx", lookup)
+		}()
+	}
+	// give the goroutines time to join the in-flight lookup
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if lookups != 1 {
+		t.Errorf("imageCache.Lookup() looked up %d images, want 1", lookups)
+	}
+}
+
+func Test_imageExists_cached(t *testing.T) {
+	existsCache = newImageCache(time.Minute, time.Minute, 10)
+	defer func() {
+		existsCache = nil
+	}()
+	cleanup := runMockRegistry()
+	if exists, _ := imageExists("nginx", nil); !exists {
+		t.Fatal("imageExists() = false, want true")
+	}
+	cleanup()
+
+	// the registry is gone, so the answer must come from the cache
+	if exists, _ := imageExists("nginx", nil); !exists {
+		t.Error("imageExists() = false, want cached true")
+	}
+}
+
+func Test_imageExists_unreachable(t *testing.T) {
+	registryTimeout = 50 * time.Millisecond
+	defer func() {
+		registryTimeout = 5 * time.Second
+		registryUnreachable = unreachableMissing
+	}()
+	defer runMockRegistry()()
+	httpmock.RegisterResponder("HEAD", "https://index.docker.io/v2/jainishshah17/nginx/manifests/unavailable",
+		httpmock.NewStringResponder(http.StatusServiceUnavailable, ``))
+	httpmock.RegisterResponder("HEAD", "https://index.docker.io/v2/jainishshah17/nginx/manifests/slow",
+		func(req *http.Request) (*http.Response, error) {
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		})
+
+	tests := []struct {
+		name        string
+		unreachable string
+		image       string
+		want        bool
+		wantErr     bool
+	}{
+		{name: "unavailable missing", unreachable: unreachableMissing, image: "jainishshah17/nginx:unavailable", want: false},
+		{name: "unavailable exists", unreachable: unreachableExists, image: "jainishshah17/nginx:unavailable", want: true},
+		{name: "unavailable deny", unreachable: unreachableDeny, image: "jainishshah17/nginx:unavailable", want: false, wantErr: true},
+		{name: "timeout exists", unreachable: unreachableExists, image: "jainishshah17/nginx:slow", want: true},
+		{name: "timeout deny", unreachable: unreachableDeny, image: "jainishshah17/nginx:slow", want: false, wantErr: true},
+		{name: "not found deny", unreachable: unreachableDeny, image: "jainishshah17/nginx:notexist", want: false},
+		{name: "not found exists", unreachable: unreachableExists, image: "jainishshah17/nginx:notexist", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registryUnreachable = tt.unreachable
+			got, err := imageExists(tt.image, nil)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("imageExists() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("imageExists() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+var policyMu sync.RWMutex
+
+// currentPolicy returns the active policy, or nil when no policy file is configured.
+// Handlers take one snapshot per request so a reload never mixes two policies.
+func currentPolicy() *Policy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	return policy
+}
+
+// setPolicy atomically replaces the active policy
+func setPolicy(p *Policy) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	policy = p
+}
+
+// policyHash returns the hash identifying a policy file's content
+func policyHash(in []byte) string {
+	sum := sha256.Sum256(in)
+	return hex.EncodeToString(sum[:])
+}
+
+// policyReloader reloads the policy file whenever it changes on disk
+type policyReloader struct {
+	filename string
+
+	mu         sync.Mutex
+	reloadedAt time.Time
+	lastError  error
+}
+
+// policyStatus is served by the status endpoint
+type policyStatus struct {
+	PolicyFile      string    `json:"policyFile,omitempty"`
+	PolicyHash      string    `json:"policyHash,omitempty"`
+	ReloadedAt      time.Time `json:"reloadedAt,omitempty"`
+	LastReloadError string    `json:"lastReloadError,omitempty"`
+}
+
+// newPolicyReloader creates a reloader for the currently active policy file
+func newPolicyReloader(filename string) *policyReloader {
+	return &policyReloader{
+		filename:   filename,
+		reloadedAt: time.Now(),
+	}
+}
+
+// reload loads the policy file and swaps it in if its content changed. An invalid file is
+// reported and the active policy is kept.
+func (r *policyReloader) reload() {
+	in, err := ioutil.ReadFile(r.filename)
+	if err != nil {
+		r.failed(err)
+		return
+	}
+	if active := currentPolicy(); active != nil && active.Hash() == policyHash(in) {
+		return
+	}
+
+	p, err := NewPolicy()
+	if err != nil {
+		r.failed(err)
+		return
+	}
+	if err := p.Load(in); err != nil {
+		r.failed(err)
+		return
+	}
+	if p.usesNamespaceSelector() {
+		startNamespaceInformer()
+		waitForNamespaceSync(namespaceSyncTimeout)
+	}
+	setPolicy(p)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reloadedAt = time.Now()
+	r.lastError = nil
+	log.WithField("policy-file", r.filename).WithField("hash", p.Hash()).Info("reloaded policy file")
+}
+
+func (r *policyReloader) failed(err error) {
+	log.WithError(err).WithField("policy-file", r.filename).Error("failed to reload policy file, keeping the active policy")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastError = err
+}
+
+// watch reloads the policy file on changes until stop is closed. The directory is watched
+// rather than the file, since Kubernetes updates mounted ConfigMaps by swapping a symlink.
+func (r *policyReloader) watch(stop <-chan struct{}) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	if err := watcher.Add(filepath.Dir(r.filename)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				log.WithField("event", event.String()).Debug("policy directory changed")
+				r.reload()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.WithError(err).Error("policy file watcher error")
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// status returns the active policy and reload state
+func (r *policyReloader) status() policyStatus {
+	s := policyStatus{}
+	if p := currentPolicy(); p != nil {
+		s.PolicyHash = p.Hash()
+	}
+	if r == nil {
+		return s
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s.PolicyFile = r.filename
+	s.ReloadedAt = r.reloadedAt
+	if r.lastError != nil {
+		s.LastReloadError = r.lastError.Error()
+	}
+	return s
+}
+
+// statusHandler responds with the active policy hash and reload state as JSON
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	log.Debugf("Serving request: %s", r.URL.Path)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reloader.status())
+}
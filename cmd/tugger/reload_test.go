@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var reloadedPolicy = `
+rules:
+- pattern: .*
+`
+
+// waitFor polls cond until it returns true or the timeout expires
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for policy reload")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// startReloader loads filename as the active policy and watches it until the test ends
+func startReloader(t *testing.T, filename string) *policyReloader {
+	t.Helper()
+	p, err := NewPolicy(WithConfigFile(filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	setPolicy(p)
+	r := newPolicyReloader(filename)
+	stop := make(chan struct{})
+	if err := r.watch(stop); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		close(stop)
+		setPolicy(nil)
+	})
+	return r
+}
+
+func Test_policyReloader_file(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(filename, []byte(defaultPolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := startReloader(t, filename)
+
+	// a valid change is swapped in
+	if err := ioutil.WriteFile(filename, []byte(reloadedPolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return currentPolicy().Hash() == policyHash([]byte(reloadedPolicy)) })
+
+	// an invalid change keeps the active policy
+	if err := ioutil.WriteFile(filename, []byte(badRegex), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return r.status().LastReloadError != "" })
+	if got := currentPolicy().Hash(); got != policyHash([]byte(reloadedPolicy)) {
+		t.Errorf("policy hash = %v after invalid reload, want %v", got, policyHash([]byte(reloadedPolicy)))
+	}
+}
+
+func Test_policyReloader_symlinkSwap(t *testing.T) {
+	// mimic how the kubelet updates a mounted ConfigMap:
+	// policy.yaml -> ..data/policy.yaml, ..data -> ..<timestamp>
+	dir := t.TempDir()
+	writeVersion := func(version, content string) {
+		if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, version, "policy.yaml"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(version, filepath.Join(dir, "..data_tmp")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(filepath.Join(dir, "..data_tmp"), filepath.Join(dir, "..data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeVersion("..v1", defaultPolicy)
+	filename := filepath.Join(dir, "policy.yaml")
+	if err := os.Symlink(filepath.Join("..data", "policy.yaml"), filename); err != nil {
+		t.Fatal(err)
+	}
+	startReloader(t, filename)
+
+	writeVersion("..v2", reloadedPolicy)
+	if err := os.RemoveAll(filepath.Join(dir, "..v1")); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return currentPolicy().Hash() == policyHash([]byte(reloadedPolicy)) })
+}
+
+func Test_statusHandler(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "policy.yaml")
+	if err := ioutil.WriteFile(filename, []byte(defaultPolicy), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defaultReloader := reloader
+	reloader = startReloader(t, filename)
+	defer func() {
+		reloader = defaultReloader
+	}()
+
+	req, err := http.NewRequest("GET", "/status", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(statusHandler).ServeHTTP(rr, req)
+
+	got := policyStatus{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.PolicyFile != filename {
+		t.Errorf("statusHandler() policyFile = %v, want %v", got.PolicyFile, filename)
+	}
+	if got.PolicyHash != policyHash([]byte(defaultPolicy)) {
+		t.Errorf("statusHandler() policyHash = %v, want %v", got.PolicyHash, policyHash([]byte(defaultPolicy)))
+	}
+}
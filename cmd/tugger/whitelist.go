@@ -0,0 +1,138 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// legacyWhitelistMatching restores the substring matching of WHITELIST_NAMESPACES and WHITELIST_REGISTRIES
+var legacyWhitelistMatching bool
+
+// namespaceWhitelist matches namespaces by exact name, or by glob e.g. kube-*
+type namespaceWhitelist []string
+
+// Match reports whether the namespace is whitelisted
+func (w namespaceWhitelist) Match(namespace string) bool {
+	for _, entry := range w {
+		if legacyWhitelistMatching {
+			if strings.Contains(entry, namespace) {
+				return true
+			}
+			continue
+		}
+		if entry == "" {
+			continue
+		}
+		if entry == namespace {
+			return true
+		}
+		if ok, _ := path.Match(entry, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// registryWhitelist matches images by registry host e.g. docker.artifactory.com, by repository
+// prefix e.g. jainishshah17, or by glob e.g. *.artifactory.com. Entries are anchored at the start of
+// the image name and only match whole path components.
+type registryWhitelist []string
+
+// Match reports whether the image is pulled from a whitelisted registry. Empty entries, from an
+// unset WHITELIST_REGISTRIES or a trailing comma, only match with legacy matching, where they
+// match every image.
+func (w registryWhitelist) Match(image string) bool {
+	for _, entry := range w {
+		if legacyWhitelistMatching {
+			if strings.Contains(image, entry) {
+				return true
+			}
+			continue
+		}
+		if entry == "" {
+			continue
+		}
+		if matchRegistry(entry, image) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRegistry(entry, image string) bool {
+	if strings.ContainsAny(entry, "*?[") {
+		// match the glob against each leading run of path components
+		components := strings.Split(image, "/")
+		for i := range components {
+			if ok, _ := path.Match(entry, strings.Join(components[:i+1], "/")); ok {
+				return true
+			}
+		}
+		return false
+	}
+	if isRegistryHost(entry) {
+		return registryHost(image) == normalizeRegistryHost(entry)
+	}
+	return image == entry || strings.HasPrefix(image, entry+"/")
+}
+
+// isRegistryHost reports whether a whitelist entry names a registry host rather than a repository
+// prefix, using the same rule as docker: a host contains a dot or a port, or is localhost
+func isRegistryHost(entry string) bool {
+	return !strings.Contains(entry, "/") &&
+		(strings.ContainsAny(entry, ".:") || entry == "localhost")
+}
+
+// registryHost returns the registry host an image is pulled from, images without one are
+// pulled from Docker Hub
+func registryHost(image string) string {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		first := strings.SplitN(image, "/", 2)[0]
+		if strings.Contains(image, "/") && isRegistryHost(first) {
+			return normalizeRegistryHost(first)
+		}
+		return ""
+	}
+	return normalizeRegistryHost(ref.Context().RegistryStr())
+}
+
+func normalizeRegistryHost(host string) string {
+	if host == "docker.io" {
+		return name.DefaultRegistry
+	}
+	return host
+}
+
+// warnAmbiguousWhitelists logs a warning for every whitelist entry that matches differently than
+// it did with the legacy substring matching
+func warnAmbiguousWhitelists(namespaces namespaceWhitelist, registries registryWhitelist) {
+	if legacyWhitelistMatching {
+		log.Warn("legacy whitelist matching is enabled: WHITELIST_NAMESPACES entries also whitelist every namespace they contain, and WHITELIST_REGISTRIES entries match anywhere in an image name")
+		for _, entry := range namespaces {
+			if entry != "" {
+				log.WithField("entry", entry).Warnf("WHITELIST_NAMESPACES entry %q whitelists any namespace that is a substring of it, including the empty namespace", entry)
+			}
+		}
+		for _, entry := range registries {
+			if entry == "" {
+				log.Warn("WHITELIST_REGISTRIES is unset or has an empty entry, which allows every image")
+				continue
+			}
+			log.WithField("entry", entry).Warnf("WHITELIST_REGISTRIES entry %q matches any image containing it, e.g. evil.io/%s", entry, entry)
+		}
+		return
+	}
+	for _, entry := range registries {
+		if entry == "" {
+			log.Warn("WHITELIST_REGISTRIES is unset or has an empty entry, which is ignored: older releases allowed every image for it (use --legacy-whitelist-matching to keep allowing every image)")
+			continue
+		}
+		if isRegistryHost(entry) || strings.ContainsAny(entry, "*?[") {
+			continue
+		}
+		log.WithField("entry", entry).Warnf("WHITELIST_REGISTRIES entry %q is not a registry host, it only matches images starting with %s/ (use --legacy-whitelist-matching to match it anywhere in the image name)", entry, entry)
+	}
+}
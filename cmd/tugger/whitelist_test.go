@@ -0,0 +1,220 @@
+package main
+
+import (
+	"testing"
+)
+
+func Test_namespaceWhitelist_Match(t *testing.T) {
+	tests := []struct {
+		name      string
+		whitelist namespaceWhitelist
+		legacy    bool
+		namespace string
+		want      bool
+	}{
+		{
+			name:      "exact",
+			whitelist: namespaceWhitelist{"kube-system", "default"},
+			namespace: "kube-system",
+			want:      true,
+		},
+		{
+			name:      "substring",
+			whitelist: namespaceWhitelist{"kube-system"},
+			namespace: "kube",
+			want:      false,
+		},
+		{
+			name:      "empty namespace",
+			whitelist: namespaceWhitelist{"kube-system"},
+			namespace: "",
+			want:      false,
+		},
+		{
+			name:      "empty whitelist",
+			whitelist: namespaceWhitelist{""},
+			namespace: "",
+			want:      false,
+		},
+		{
+			name:      "glob",
+			whitelist: namespaceWhitelist{"kube-*"},
+			namespace: "kube-public",
+			want:      true,
+		},
+		{
+			name:      "glob mismatch",
+			whitelist: namespaceWhitelist{"kube-*"},
+			namespace: "my-kube-public",
+			want:      false,
+		},
+		{
+			name:      "legacy substring",
+			whitelist: namespaceWhitelist{"kube-system"},
+			legacy:    true,
+			namespace: "system",
+			want:      true,
+		},
+		{
+			name:      "legacy empty whitelist",
+			whitelist: namespaceWhitelist{""},
+			legacy:    true,
+			namespace: "",
+			want:      true,
+		},
+		{
+			name:      "legacy empty whitelist mismatch",
+			whitelist: namespaceWhitelist{""},
+			legacy:    true,
+			namespace: "default",
+			want:      false,
+		},
+		{
+			name:      "legacy mismatch",
+			whitelist: namespaceWhitelist{"kube-system"},
+			legacy:    true,
+			namespace: "foobar",
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			legacyWhitelistMatching = tt.legacy
+			defer func() {
+				legacyWhitelistMatching = false
+			}()
+			if got := tt.whitelist.Match(tt.namespace); got != tt.want {
+				t.Errorf("namespaceWhitelist.Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_registryWhitelist_Match(t *testing.T) {
+	tests := []struct {
+		name      string
+		whitelist registryWhitelist
+		legacy    bool
+		image     string
+		want      bool
+	}{
+		{
+			name:      "repository prefix",
+			whitelist: registryWhitelist{"jainishshah17"},
+			image:     "jainishshah17/nginx:1.0",
+			want:      true,
+		},
+		{
+			name:      "repository prefix elsewhere",
+			whitelist: registryWhitelist{"jainishshah17"},
+			image:     "evil.io/jainishshah17/nginx",
+			want:      false,
+		},
+		{
+			name:      "repository prefix partial component",
+			whitelist: registryWhitelist{"jainishshah17"},
+			image:     "jainishshah17-evil/nginx",
+			want:      false,
+		},
+		{
+			name:      "registry host",
+			whitelist: registryWhitelist{"docker.artifactory.com"},
+			image:     "docker.artifactory.com/nginx@sha256:2d4f6d8aec48cf4e5b9a5e9d4e8f5d5c8a4b8f3d3c1e0f4e1a5a8f6b7c9d0e1f",
+			want:      true,
+		},
+		{
+			name:      "registry host suffix",
+			whitelist: registryWhitelist{"artifactory.com"},
+			image:     "docker.artifactory.com/nginx",
+			want:      false,
+		},
+		{
+			name:      "registry host in path",
+			whitelist: registryWhitelist{"docker.artifactory.com"},
+			image:     "evil.io/docker.artifactory.com/nginx",
+			want:      false,
+		},
+		{
+			name:      "registry host with port",
+			whitelist: registryWhitelist{"10.110.50.0:5000"},
+			image:     "10.110.50.0:5000/nginx",
+			want:      true,
+		},
+		{
+			name:      "docker hub",
+			whitelist: registryWhitelist{"docker.io"},
+			image:     "nginx",
+			want:      true,
+		},
+		{
+			name:      "docker hub mismatch",
+			whitelist: registryWhitelist{"docker.io"},
+			image:     "quay.io/nginx",
+			want:      false,
+		},
+		{
+			name:      "glob host",
+			whitelist: registryWhitelist{"*.artifactory.com"},
+			image:     "docker.artifactory.com/nginx",
+			want:      true,
+		},
+		{
+			name:      "glob repository",
+			whitelist: registryWhitelist{"jainishshah17/*"},
+			image:     "jainishshah17/nginx:1.0",
+			want:      true,
+		},
+		{
+			name:      "glob mismatch",
+			whitelist: registryWhitelist{"*.artifactory.com"},
+			image:     "evil.io/docker.artifactory.com/nginx",
+			want:      false,
+		},
+		{
+			name:      "empty whitelist",
+			whitelist: registryWhitelist{""},
+			image:     "nginx",
+			want:      false,
+		},
+		{
+			name:      "legacy substring",
+			whitelist: registryWhitelist{"jainishshah17"},
+			legacy:    true,
+			image:     "evil.io/jainishshah17/nginx",
+			want:      true,
+		},
+		{
+			name:      "legacy empty whitelist",
+			whitelist: registryWhitelist{""},
+			legacy:    true,
+			image:     "nginx",
+			want:      true,
+		},
+		{
+			name:      "legacy trailing comma",
+			whitelist: registryWhitelist{"jainishshah17", ""},
+			legacy:    true,
+			image:     "evil.io/nginx",
+			want:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			legacyWhitelistMatching = tt.legacy
+			defer func() {
+				legacyWhitelistMatching = false
+			}()
+			if got := tt.whitelist.Match(tt.image); got != tt.want {
+				t.Errorf("registryWhitelist.Match() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_warnAmbiguousWhitelists(t *testing.T) {
+	for _, legacy := range []bool{false, true} {
+		legacyWhitelistMatching = legacy
+		warnAmbiguousWhitelists(namespaceWhitelist{"kube-system", ""}, registryWhitelist{"jainishshah17", "docker.artifactory.com", ""})
+	}
+	legacyWhitelistMatching = false
+}
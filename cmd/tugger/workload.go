@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// podTemplate is the pod spec and metadata embedded in an admitted object
+type podTemplate struct {
+	// path is the JSON pointer to the pod template within the object, empty for a bare Pod
+	path string
+	meta *metav1.ObjectMeta
+	spec *v1.PodSpec
+	// ephemeralOnly is set for pods/ephemeralcontainers updates, where only the ephemeral
+	// containers may change and the rest of the pod spec was admitted when it was created
+	ephemeralOnly bool
+	// oldEphemeral names the ephemeral containers the pod already had, which can not change
+	oldEphemeral map[string]bool
+	// admittedThrough is the kind of the controller whose own pod template was already admitted,
+	// e.g. the Deployment of a ReplicaSet, empty when the object has to be admitted itself
+	admittedThrough string
+}
+
+// newPodTemplate decodes the object in an admission request and locates its pod template by the
+// request kind. Pods and the workload controllers that embed a pod template are supported, nil is
+// returned for any other kind. ReplicaSets controlled by a Deployment are marked as admitted through
+// it.
+func newPodTemplate(req *admissionv1.AdmissionRequest) (*podTemplate, error) {
+	raw := req.Object.Raw
+	switch req.Kind.Kind {
+	case "Pod":
+		obj := v1.Pod{}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, err
+		}
+		tpl := &podTemplate{
+			meta:          &obj.ObjectMeta,
+			spec:          &obj.Spec,
+			ephemeralOnly: req.SubResource == "ephemeralcontainers",
+		}
+		if tpl.ephemeralOnly && len(req.OldObject.Raw) > 0 {
+			old := v1.Pod{}
+			if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+				return nil, err
+			}
+			tpl.oldEphemeral = map[string]bool{}
+			for _, ec := range old.Spec.EphemeralContainers {
+				tpl.oldEphemeral[ec.Name] = true
+			}
+		}
+		return tpl, nil
+	case "Deployment":
+		obj := appsv1.Deployment{}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, err
+		}
+		return newTemplate("/spec/template", &obj.Spec.Template), nil
+	case "StatefulSet":
+		obj := appsv1.StatefulSet{}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, err
+		}
+		return newTemplate("/spec/template", &obj.Spec.Template), nil
+	case "DaemonSet":
+		obj := appsv1.DaemonSet{}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, err
+		}
+		return newTemplate("/spec/template", &obj.Spec.Template), nil
+	case "ReplicaSet":
+		obj := appsv1.ReplicaSet{}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, err
+		}
+		tpl := newTemplate("/spec/template", &obj.Spec.Template)
+		// The Deployment controller matches its ReplicaSets to its pod template, so mutating them
+		// differently than the Deployment makes it create new ReplicaSets over and over
+		if owner := metav1.GetControllerOf(&obj); owner != nil && owner.Kind == "Deployment" {
+			tpl.admittedThrough = owner.Kind
+		}
+		return tpl, nil
+	case "Job":
+		obj := batchv1.Job{}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, err
+		}
+		return newTemplate("/spec/template", &obj.Spec.Template), nil
+	case "CronJob":
+		obj := batchv1.CronJob{}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return nil, err
+		}
+		return newTemplate("/spec/jobTemplate/spec/template", &obj.Spec.JobTemplate.Spec.Template), nil
+	}
+	return nil, nil
+}
+
+func newTemplate(path string, tpl *v1.PodTemplateSpec) *podTemplate {
+	return &podTemplate{path: path, meta: &tpl.ObjectMeta, spec: &tpl.Spec}
+}
+
+// missingMetadata reports whether a workload's pod template omits metadata entirely, in which case
+// it has to be created before annotations or labels can be patched in
+func (t *podTemplate) missingMetadata() bool {
+	return t.path != "" && reflect.DeepEqual(*t.meta, metav1.ObjectMeta{})
+}
+
+// ephemeralContainers returns the pod's ephemeral containers as regular containers, so they can be
+// handled the same way
+func (t *podTemplate) ephemeralContainers() []v1.Container {
+	containers := []v1.Container{}
+	for _, ec := range t.spec.EphemeralContainers {
+		containers = append(containers, v1.Container(ec.EphemeralContainerCommon))
+	}
+	return containers
+}
+
+// containerRef is a container along with its position in the pod spec
+type containerRef struct {
+	v1.Container
+	// field is the pod spec field holding the container: containers, initContainers or ephemeralContainers
+	field string
+	index int
+}
+
+// containerTypes names the kind of container held in each pod spec field
+var containerTypes = map[string]string{
+	"containers":          "container",
+	"initContainers":      "init container",
+	"ephemeralContainers": "ephemeral container",
+}
+
+// originalImageAnnotations prefixes the annotations recording the original image of mutated
+// containers in each pod spec field, e.g. tugger-original-init-image-0. Ephemeral containers have
+// none, the API server ignores metadata changes through the pods/ephemeralcontainers subresource.
+var originalImageAnnotations = map[string]string{
+	"containers":     "tugger-original-image",
+	"initContainers": "tugger-original-init-image",
+}
+
+// containers returns every container in the pod template that needs to be admitted. For
+// pods/ephemeralcontainers updates, these are the ephemeral containers the request adds.
+func (t *podTemplate) containers() []containerRef {
+	refs := []containerRef{}
+	if !t.ephemeralOnly {
+		for i, c := range t.spec.Containers {
+			refs = append(refs, containerRef{Container: c, field: "containers", index: i})
+		}
+		for i, c := range t.spec.InitContainers {
+			refs = append(refs, containerRef{Container: c, field: "initContainers", index: i})
+		}
+	}
+	for i, c := range t.ephemeralContainers() {
+		if t.oldEphemeral[c.Name] {
+			continue
+		}
+		refs = append(refs, containerRef{Container: c, field: "ephemeralContainers", index: i})
+	}
+	return refs
+}
+
+// imageField returns the field path of a container's image within the admitted object,
+// e.g. spec.template.spec.containers[2].image
+func (t *podTemplate) imageField(ref containerRef) string {
+	prefix := strings.ReplaceAll(strings.TrimPrefix(t.path, "/"), "/", ".")
+	if prefix != "" {
+		prefix += "."
+	}
+	return fmt.Sprintf("%sspec.%s[%d].image", prefix, ref.field, ref.index)
+}
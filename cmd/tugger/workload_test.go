@@ -0,0 +1,225 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func Test_newPodTemplate(t *testing.T) {
+	tests := []struct {
+		name            string
+		kind            string
+		subResource     string
+		object          string
+		wantNil         bool
+		wantPath        string
+		wantImage       string
+		missingMetadata bool
+		ephemeralOnly   bool
+		admittedThrough string
+		wantErr         bool
+	}{
+		{
+			name:      "pod",
+			kind:      "Pod",
+			object:    `{"metadata":{"name":"myapp"},"spec":{"containers":[{"image":"nginx"}]}}`,
+			wantPath:  "",
+			wantImage: "nginx",
+		},
+		{
+			name:          "pod ephemeral containers",
+			kind:          "Pod",
+			subResource:   "ephemeralcontainers",
+			object:        `{"metadata":{"name":"myapp"},"spec":{"containers":[{"image":"nginx"}],"ephemeralContainers":[{"image":"busybox"}]}}`,
+			wantPath:      "",
+			wantImage:     "nginx",
+			ephemeralOnly: true,
+		},
+		{
+			name:      "deployment",
+			kind:      "Deployment",
+			object:    `{"spec":{"template":{"metadata":{"labels":{"app":"myapp"}},"spec":{"containers":[{"image":"nginx"}]}}}}`,
+			wantPath:  "/spec/template",
+			wantImage: "nginx",
+		},
+		{
+			name:      "statefulset",
+			kind:      "StatefulSet",
+			object:    `{"spec":{"template":{"metadata":{"labels":{"app":"myapp"}},"spec":{"containers":[{"image":"mysql"}]}}}}`,
+			wantPath:  "/spec/template",
+			wantImage: "mysql",
+		},
+		{
+			name:      "daemonset",
+			kind:      "DaemonSet",
+			object:    `{"spec":{"template":{"metadata":{"labels":{"app":"myapp"}},"spec":{"containers":[{"image":"fluentd"}]}}}}`,
+			wantPath:  "/spec/template",
+			wantImage: "fluentd",
+		},
+		{
+			name:      "replicaset",
+			kind:      "ReplicaSet",
+			object:    `{"spec":{"template":{"metadata":{"labels":{"app":"myapp"}},"spec":{"containers":[{"image":"nginx"}]}}}}`,
+			wantPath:  "/spec/template",
+			wantImage: "nginx",
+		},
+		{
+			name:            "replicaset of a deployment",
+			kind:            "ReplicaSet",
+			object:          `{"metadata":{"ownerReferences":[{"apiVersion":"apps/v1","kind":"Deployment","name":"myapp","uid":"1","controller":true}]},"spec":{"template":{"metadata":{"labels":{"app":"myapp"}},"spec":{"containers":[{"image":"nginx"}]}}}}`,
+			wantPath:        "/spec/template",
+			wantImage:       "nginx",
+			admittedThrough: "Deployment",
+		},
+		{
+			name:      "replicaset with a non-controller owner",
+			kind:      "ReplicaSet",
+			object:    `{"metadata":{"ownerReferences":[{"apiVersion":"apps/v1","kind":"Deployment","name":"myapp","uid":"1"}]},"spec":{"template":{"metadata":{"labels":{"app":"myapp"}},"spec":{"containers":[{"image":"nginx"}]}}}}`,
+			wantPath:  "/spec/template",
+			wantImage: "nginx",
+		},
+		{
+			name:            "job",
+			kind:            "Job",
+			object:          `{"spec":{"template":{"spec":{"containers":[{"image":"busybox"}]}}}}`,
+			wantPath:        "/spec/template",
+			wantImage:       "busybox",
+			missingMetadata: true,
+		},
+		{
+			name:            "cronjob",
+			kind:            "CronJob",
+			object:          `{"spec":{"jobTemplate":{"spec":{"template":{"spec":{"containers":[{"image":"busybox"}]}}}}}}`,
+			wantPath:        "/spec/jobTemplate/spec/template",
+			wantImage:       "busybox",
+			missingMetadata: true,
+		},
+		{
+			name:    "unhandled kind",
+			kind:    "ConfigMap",
+			object:  `{"data":{"foo":"bar"}}`,
+			wantNil: true,
+		},
+		{
+			name:    "bad object",
+			kind:    "Deployment",
+			object:  `{"spec":[]}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &admissionv1.AdmissionRequest{
+				Kind:        metav1.GroupVersionKind{Kind: tt.kind},
+				SubResource: tt.subResource,
+				Object:      runtime.RawExtension{Raw: []byte(tt.object)},
+			}
+			got, err := newPodTemplate(req)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newPodTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (got == nil) != tt.wantNil {
+				t.Fatalf("newPodTemplate() = %v, wantNil %v", got, tt.wantNil)
+			}
+			if tt.wantNil {
+				return
+			}
+			if got.path != tt.wantPath {
+				t.Errorf("newPodTemplate() path = %v, want %v", got.path, tt.wantPath)
+			}
+			if got.spec.Containers[0].Image != tt.wantImage {
+				t.Errorf("newPodTemplate() image = %v, want %v", got.spec.Containers[0].Image, tt.wantImage)
+			}
+			if got.ephemeralOnly != tt.ephemeralOnly {
+				t.Errorf("newPodTemplate() ephemeralOnly = %v, want %v", got.ephemeralOnly, tt.ephemeralOnly)
+			}
+			if got.admittedThrough != tt.admittedThrough {
+				t.Errorf("newPodTemplate() admittedThrough = %v, want %v", got.admittedThrough, tt.admittedThrough)
+			}
+			if got.missingMetadata() != tt.missingMetadata {
+				t.Errorf("podTemplate.missingMetadata() = %v, want %v", got.missingMetadata(), tt.missingMetadata)
+			}
+		})
+	}
+}
+
+func Test_podTemplate_ephemeralContainers(t *testing.T) {
+	req := &admissionv1.AdmissionRequest{
+		Kind:   metav1.GroupVersionKind{Kind: "Pod"},
+		Object: runtime.RawExtension{Raw: []byte(`{"spec":{"ephemeralContainers":[{"name":"debugger","image":"busybox"}]}}`)},
+	}
+	tpl, err := newPodTemplate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := tpl.ephemeralContainers()
+	if len(got) != 1 || got[0].Name != "debugger" || got[0].Image != "busybox" {
+		t.Errorf("podTemplate.ephemeralContainers() = %v", got)
+	}
+}
+
+func Test_podTemplate_imageField(t *testing.T) {
+	tests := []struct {
+		name        string
+		kind        string
+		subResource string
+		object      string
+		oldObject   string
+		want        []string
+	}{
+		{
+			name:   "pod",
+			kind:   "Pod",
+			object: `{"spec":{"containers":[{"image":"nginx"},{"image":"mysql"}],"initContainers":[{"image":"busybox"}]}}`,
+			want:   []string{"spec.containers[0].image", "spec.containers[1].image", "spec.initContainers[0].image"},
+		},
+		{
+			name:        "pod ephemeral containers",
+			kind:        "Pod",
+			subResource: "ephemeralcontainers",
+			object:      `{"spec":{"containers":[{"image":"nginx"}],"ephemeralContainers":[{"image":"busybox"}]}}`,
+			want:        []string{"spec.ephemeralContainers[0].image"},
+		},
+		{
+			name:        "pod added ephemeral container",
+			kind:        "Pod",
+			subResource: "ephemeralcontainers",
+			object:      `{"spec":{"containers":[{"image":"nginx"}],"ephemeralContainers":[{"name":"old","image":"alpine"},{"name":"new","image":"busybox"}]}}`,
+			oldObject:   `{"spec":{"containers":[{"image":"nginx"}],"ephemeralContainers":[{"name":"old","image":"alpine"}]}}`,
+			want:        []string{"spec.ephemeralContainers[1].image"},
+		},
+		{
+			name:   "cronjob",
+			kind:   "CronJob",
+			object: `{"spec":{"jobTemplate":{"spec":{"template":{"spec":{"initContainers":[{"image":"busybox"}]}}}}}}`,
+			want:   []string{"spec.jobTemplate.spec.template.spec.initContainers[0].image"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl, err := newPodTemplate(&admissionv1.AdmissionRequest{
+				Kind:        metav1.GroupVersionKind{Kind: tt.kind},
+				SubResource: tt.subResource,
+				Object:      runtime.RawExtension{Raw: []byte(tt.object)},
+				OldObject:   runtime.RawExtension{Raw: []byte(tt.oldObject)},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, ref := range tpl.containers() {
+				got = append(got, tpl.imageField(ref))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("podTemplate.imageField() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}